@@ -0,0 +1,162 @@
+package libspack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cam72cam/go-lumberjack/color"
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/lock"
+	"github.com/serenitylinux/libspack/misc"
+	"github.com/serenitylinux/libspack/repo"
+)
+
+type installedPkg struct {
+	set  repo.PkgInstallSet
+	repo *repo.Repo
+}
+
+func installedInRoot(root string) (map[string]installedPkg, error) {
+	installed := make(map[string]installedPkg)
+	for _, r := range repo.GetAllRepos() {
+		r := r
+		err := r.MapInstalled(root, func(set repo.PkgInstallSet) {
+			installed[set.PkgInfo.Name] = installedPkg{set, r}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return installed, nil
+}
+
+func findOrphans(root string) ([]installedPkg, error) {
+	installed, err := installedInRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	world, err := repo.LoadWorld(root)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := make(map[string]bool)
+	var keep func(name string)
+	keep = func(name string) {
+		pkg, ok := installed[name]
+		if !ok || needed[name] {
+			return
+		}
+		needed[name] = true
+		for _, dep := range pkg.set.EnabledDeps() {
+			keep(dep.Name)
+		}
+	}
+
+	for name, pkg := range installed {
+		if pkg.set.Reason == repo.ReasonExplicit || world.Contains(name) {
+			keep(name)
+		}
+	}
+
+	orphans := make([]installedPkg, 0)
+	for name, pkg := range installed {
+		if !needed[name] {
+			orphans = append(orphans, pkg)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].set.PkgInfo.Name < orphans[j].set.PkgInfo.Name
+	})
+	return dependenciesFirst(orphans), nil
+}
+
+//Orders pkgs with dependencies before their dependents, as removePlan expects
+func dependenciesFirst(pkgs []installedPkg) []installedPkg {
+	byName := make(map[string]installedPkg, len(pkgs))
+	for _, pkg := range pkgs {
+		byName[pkg.set.PkgInfo.Name] = pkg
+	}
+
+	ordered := make([]installedPkg, 0, len(pkgs))
+	visited := make(map[string]bool)
+	var visit func(pkg installedPkg)
+	visit = func(pkg installedPkg) {
+		if visited[pkg.set.PkgInfo.Name] {
+			return
+		}
+		visited[pkg.set.PkgInfo.Name] = true
+		for _, dep := range pkg.set.EnabledDeps() {
+			if other, ok := byName[dep.Name]; ok {
+				visit(other)
+			}
+		}
+		ordered = append(ordered, pkg)
+	}
+	for _, pkg := range pkgs {
+		visit(pkg)
+	}
+	return ordered
+}
+
+//Installed packages which were pulled in as dependencies and are no longer
+//needed by any explicitly requested package
+func Orphans(root string) ([]repo.PkgInstallSet, error) {
+	rootLock, err := lock.Shared(root)
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	orphans, err := findOrphans(root)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]repo.PkgInstallSet, 0, len(orphans))
+	for _, pkg := range orphans {
+		sets = append(sets, pkg.set)
+	}
+	return sets, nil
+}
+
+func Autoremove(root string) error {
+	rootLock, err := lock.Exclusive(root)
+	if err != nil {
+		return err
+	}
+	defer rootLock.Release()
+
+	if err := recoverRoot(root); err != nil {
+		return err
+	}
+
+	orphans, err := findOrphans(root)
+	if err != nil {
+		return fmt.Errorf("Unable to find orphaned packages: %v", err.Error())
+	}
+
+	if len(orphans) == 0 {
+		log.Info.Println("Nothing to do")
+		return nil
+	}
+
+	if err := checkRemovable(orphans, root); err != nil {
+		return err
+	}
+
+	fmt.Println(color.White.String("Packages to Remove:"))
+	for _, pkg := range orphans {
+		fmt.Printf("%s (%s, %s)\n", pkg.set.PkgInfo.PrettyString(), pkg.set.Reason, pkg.set.InstallDate.Format("2006-01-02"))
+	}
+
+	if !misc.AskYesNo("Do you wish to continue?", true) {
+		return nil
+	}
+
+	if err := removePlan(orphans, root); err != nil {
+		return err
+	}
+
+	misc.PrintSuccess()
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/serenitylinux/libspack/argparse"
+	"github.com/serenitylinux/libspack/serve"
+)
+
+var addr = argparse.RegisterString("addr", ":8080", "Address to listen on")
+var username = argparse.RegisterString("user", "", "Require basic auth with this user")
+//Never taken on the command line, where it would show up in ps and shell history
+const PasswordEnv = "SPACK_SERVE_PASSWORD"
+
+var passwordFile = argparse.RegisterString("password-file", "", "File holding the password for --user, $"+PasswordEnv+" if not set")
+var uploads = argparse.RegisterBool("uploads", false, "Accept spakgs uploaded with PUT, requires --user")
+
+func main() {
+	argparse.SetBasename(fmt.Sprintf("%s [options] spakgdir", os.Args[0]))
+	args := argparse.EvalDefaultArgs()
+	if len(args) != 1 {
+		argparse.Usage(2)
+	}
+
+	password := os.Getenv(PasswordEnv)
+	if file := passwordFile.Get(); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	opts := serve.Options{
+		Username: username.Get(),
+		Password: password,
+		Uploads:  uploads.Get(),
+	}
+	if err := serve.ListenAndServe(addr.Get(), args[0], opts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,28 @@
+package libspack
+
+import (
+	"github.com/serenitylinux/libspack/lock"
+	"github.com/serenitylinux/libspack/repo"
+)
+
+//Config files in root kept because they were modified, with a new version waiting
+func PendingConfigs(root string) ([]string, error) {
+	rootLock, err := lock.Shared(root)
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	return repo.PendingConfigs(root)
+}
+
+//Replaces the config file at path with its new version if useNew, otherwise keeps the current one
+func MergeConfig(path string, root string, useNew bool) error {
+	rootLock, err := lock.Exclusive(root)
+	if err != nil {
+		return err
+	}
+	defer rootLock.Release()
+
+	return repo.MergeConfig(path, root, useNew)
+}
@@ -2,23 +2,35 @@ package constraintconfig
 
 import (
 	"bufio"
-	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
-	"github.com/cam72cam/go-lumberjack/log"
 	"github.com/serenitylinux/libspack/misc"
 	"github.com/serenitylinux/libspack/spdl"
 )
 
-type ConstraintList map[string]spdl.Dep
+//A single package constraint and where it was configured
+type Constraint struct {
+	Dep  spdl.Dep
+	File string
+	Line int
+}
+
+func (c Constraint) Origin() string {
+	return fmt.Sprintf("%s:%d", c.File, c.Line)
+}
+
+type ConstraintList map[string]Constraint
 
 func (list ConstraintList) addFile(path string) error {
 	var interr error
 	err := misc.WithFileReader(path, func(r io.Reader) {
 		scanner := bufio.NewScanner(r)
+		lineno := 0
 		for scanner.Scan() {
+			lineno++
 			line := scanner.Text()
 			if len(line) == 0 {
 				continue
@@ -27,20 +39,20 @@ func (list ConstraintList) addFile(path string) error {
 			d, err := spdl.ParseDep(line)
 
 			if err != nil {
-				interr = err
+				interr = fmt.Errorf("%s:%d: %v", path, lineno, err)
 				return
 			}
 
 			if d.Condition != nil {
-				interr = errors.New("Cannot have a condition in a constraint config file: " + line)
+				interr = fmt.Errorf("%s:%d: Cannot have a condition in a constraint config file: %s", path, lineno, line)
 				return
 			}
-			if d.Version1 == nil && d.Version2 == nil && (d.Flags == nil || len(d.Flags.Slice()) == 0) {
-				interr = errors.New("Package " + d.Name + " has no constraints specified")
+			if d.Repo == "" && d.Version1 == nil && d.Version2 == nil && (d.Flags == nil || len(d.Flags.Slice()) == 0) {
+				interr = fmt.Errorf("%s:%d: Package %s has no constraints specified", path, lineno, d.Name)
 				return
 			}
 
-			list[d.Name] = d
+			list[d.Name] = Constraint{Dep: d, File: path, Line: lineno}
 		}
 		if err := scanner.Err(); err != nil {
 			interr = err
@@ -53,37 +65,32 @@ func (list ConstraintList) addFile(path string) error {
 	return err
 }
 
-var cached = make(map[string]ConstraintList)
-
-func GetAll(root string) ConstraintList {
-	if list, exists := cached[root]; exists {
-		return list
-	}
-
-	pre := root + "/etc/spack/pkg"
+//Reads the constraints configured in root, every call sees the files as they are now
+func GetAll(root string) (ConstraintList, error) {
+	pre := filepath.Clean(root + "/etc/spack/pkg")
 	fl := make(ConstraintList, 0)
 
 	if misc.PathExists(pre + ".conf") {
 		err := fl.addFile(pre + ".conf")
 		if err != nil {
-			log.Error.Println(err)
-			return nil
+			return nil, err
 		}
 	}
 
 	if misc.PathExists(pre) {
 		err := filepath.Walk(pre, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
 			if !f.IsDir() {
 				return fl.addFile(path)
 			}
 			return nil
 		})
 		if err != nil {
-			log.Error.Println(err)
-			return nil
+			return nil, err
 		}
 	}
 
-	cached[root] = fl
-	return fl
+	return fl, nil
 }
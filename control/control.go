@@ -1,11 +1,9 @@
 package control
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/serenitylinux/libspack/spdl"
 )
@@ -22,10 +20,32 @@ type Control struct {
 	Bdeps spdl.DepList
 	Deps  spdl.DepList
 	Flags spdl.FlagExprList
+
+	Replaces  []string //Packages whose files this package may take over
+	Conffiles []string //Paths or directories (ending in /) kept when modified locally
 	//Provides (libjpeg, cc)
 	//Provides Hook (update mime types)
 }
 
+//Everything under these is treated as configuration
+var ConfigPrefixes = []string{"/etc/"}
+
+func (c Control) IsConfig(path string) bool {
+	path = filepath.Clean("/" + path)
+	matches := func(prefixes []string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) {
+				return true
+			}
+			if path == filepath.Clean(prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(ConfigPrefixes) || matches(c.Conffiles)
+}
+
 //Hack for older controls for now
 func (c *Control) FlagsCrunch() {
 	//changed := false
@@ -74,63 +94,3 @@ func (c Control) GreaterThan(other Control) bool {
 	}
 	return spdl.NewVersion(spdl.GT, other.Version).Accepts(c.Version)
 }
-
-func FromTemplateFile(template string) (c Control, err error) {
-	commands := `
-template=` + template + `
-default=$(dirname $template)/default
-
-. $template
-if [ -f "$default" ]; then
-	. $default
-fi
-
-function lister() {
-	local set i
-	set=""
-	for i in ${!curr[*]}; do
-		echo -en "$set\"${curr[$i]}\""
-		set=", "
-	done
-}
-
-curr=("${src[@]}")
-srcval="$(lister)"
-
-curr=("${bdeps[@]}")
-bdepsval="$(lister)"
-
-curr=("${deps[@]}")
-depsval="$(lister)"
-
-curr=("${arch[@]}")
-archval="$(lister)"
-
-curr=("${flags[@]}")
-flagsval="$(lister)"
-
-cat << EOT
-{
-  "Name": "$name",
-  "Version": "$version",
-  "Iteration": $iteration,
-  "Description": "$desc",
-  "Url": "$url",
-  "Src": [$srcval],
-  "Bdeps": [ $bdepsval ],
-  "Deps": [ $depsval ],
-  "Arch": [ $archval ],
-  "Flags": [ $flagsval ]
-}
-EOT`
-	var buf bytes.Buffer
-	cmd := exec.Command("bash", "-ec", commands)
-	cmd.Stdout = &buf
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		return c, err
-	}
-	err = json.Unmarshal(buf.Bytes(), &c)
-	return c, err
-}
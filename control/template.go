@@ -0,0 +1,199 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//Bumped whenever how controls are extracted from templates changes, so controls
+//cached by an older version are evaluated again
+const TemplateVersion = 1
+
+//Longest a template may take to evaluate
+var TemplateTimeout = 30 * time.Second
+
+//Environment templates are evaluated in, nothing is inherited from the caller
+var TemplateEnv = []string{
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	"HOME=/",
+	"LANG=C",
+}
+
+//Each field is written as its name, the number of values and the values, all
+//NUL terminated. Bash strings can not contain NUL so no value needs escaping
+const templateScript = `
+template=$1
+default=$(dirname "$template")/default
+
+#Anything the template prints would corrupt the values
+. "$template" >&2
+if [ -f "$default" ]; then
+	. "$default" >&2
+fi
+
+emit() {
+	local field=$1
+	shift
+	printf '%s\0%s\0' "$field" "$#"
+	if [ $# -ne 0 ]; then
+		printf '%s\0' "$@"
+	fi
+}
+
+emit Name "$name"
+emit Version "$version"
+emit Iteration "$iteration"
+emit Description "$desc"
+emit Url "$url"
+emit Src "${src[@]}"
+emit Bdeps "${bdeps[@]}"
+emit Deps "${deps[@]}"
+emit Arch "${arch[@]}"
+emit Flags "${flags[@]}"
+emit Replaces "${replaces[@]}"
+emit Conffiles "${conffiles[@]}"
+`
+
+type TemplateError struct {
+	Template string
+	Field    string //Empty if the template could not be evaluated at all
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("Invalid template %s: %v", e.Template, e.Err)
+	}
+	return fmt.Sprintf("Invalid %s in template %s: %v", e.Field, e.Template, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+func FromTemplateFile(template string) (c Control, err error) {
+	//Sourcing a bare file name would search PATH
+	if template, err = filepath.Abs(template); err != nil {
+		return c, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TemplateTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "bash", "-ec", templateScript, "bash", template)
+	cmd.Env = TemplateEnv
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	//Kill anything the template started along with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("Timed out after %v", TemplateTimeout)
+		} else if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return c, &TemplateError{Template: template, Err: err}
+	}
+	return decodeTemplate(template, stdout.Bytes())
+}
+
+func splitFields(out []byte) (map[string][]string, error) {
+	tokens := strings.Split(string(out), "\x00")
+	if tokens[len(tokens)-1] != "" {
+		return nil, fmt.Errorf("Truncated output")
+	}
+	tokens = tokens[:len(tokens)-1]
+
+	fields := make(map[string][]string)
+	for i := 0; i < len(tokens); {
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("Truncated output")
+		}
+		name := tokens[i]
+		n, err := strconv.Atoi(tokens[i+1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid value count for %s", name)
+		}
+		i += 2
+		if i+n > len(tokens) {
+			return nil, fmt.Errorf("Truncated output")
+		}
+		fields[name] = tokens[i : i+n]
+		i += n
+	}
+	return fields, nil
+}
+
+func decodeTemplate(template string, out []byte) (c Control, err error) {
+	fields, err := splitFields(out)
+	if err != nil {
+		return c, &TemplateError{Template: template, Err: err}
+	}
+
+	//Lists go through the types' own JSON decoding, which parses deps and flags
+	decode := func(name string, single bool, dest interface{}) error {
+		values, ok := fields[name]
+		if !ok {
+			return &TemplateError{Template: template, Field: name, Err: fmt.Errorf("Missing")}
+		}
+		var val interface{} = values
+		if single {
+			if len(values) != 1 {
+				return &TemplateError{Template: template, Field: name, Err: fmt.Errorf("Expected one value, got %d", len(values))}
+			}
+			val = values[0]
+		}
+		data, err := json.Marshal(val)
+		if err == nil {
+			err = json.Unmarshal(data, dest)
+		}
+		if err != nil {
+			return &TemplateError{Template: template, Field: name, Err: err}
+		}
+		return nil
+	}
+
+	var iteration string
+	decoders := []struct {
+		name   string
+		single bool
+		dest   interface{}
+	}{
+		{"Name", true, &c.Name},
+		{"Version", true, &c.Version},
+		{"Iteration", true, &iteration},
+		{"Description", true, &c.Description},
+		{"Url", true, &c.Url},
+		{"Src", false, &c.Src},
+		{"Bdeps", false, &c.Bdeps},
+		{"Deps", false, &c.Deps},
+		{"Arch", false, &c.Arch},
+		{"Flags", false, &c.Flags},
+		{"Replaces", false, &c.Replaces},
+		{"Conffiles", false, &c.Conffiles},
+	}
+	for _, d := range decoders {
+		if err := decode(d.name, d.single, d.dest); err != nil {
+			return c, err
+		}
+	}
+
+	if c.Iteration, err = strconv.Atoi(strings.TrimSpace(iteration)); err != nil {
+		return c, &TemplateError{Template: template, Field: "Iteration", Err: fmt.Errorf("Not a number: %q", iteration)}
+	}
+	return c, nil
+}
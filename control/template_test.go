@@ -0,0 +1,88 @@
+package control
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplate(t *testing.T, dir string, content string) string {
+	file := filepath.Join(dir, "test.pie")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestFromTemplateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Setenv("TEMPLATE_SECRET", "leaked")
+	file := writeTemplate(t, dir, `
+name=test
+version=1.0
+iteration=2
+desc='A "quoted" \back\slash
+and a second line'
+url="$TEMPLATE_SECRET"
+deps=( "a>=1.0" "b" )
+flags=( "+dev" )
+conffiles=( "/etc/with space" )
+echo "printed by the template"
+`)
+	c, err := FromTemplateFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "test" || c.Version != "1.0" || c.Iteration != 2 {
+		t.Errorf("Unexpected control %+v", c)
+	}
+	if c.Description != "A \"quoted\" \\back\\slash\nand a second line" {
+		t.Errorf("Description was not kept as is: %q", c.Description)
+	}
+	if c.Url != "" {
+		t.Errorf("Template should not see the caller's environment, got %q", c.Url)
+	}
+	if len(c.Deps) != 2 || c.Deps[0].Name != "a" || len(c.Flags) != 1 {
+		t.Errorf("Unexpected deps %v or flags %v", c.Deps, c.Flags)
+	}
+	if len(c.Conffiles) != 1 || c.Conffiles[0] != "/etc/with space" || c.Src == nil || len(c.Src) != 0 {
+		t.Errorf("Unexpected lists %+v", c)
+	}
+
+	file = writeTemplate(t, dir, "name=test\nversion=1.0\niteration=1\nbdeps=( 'ok' '(bad' )\n")
+	_, err = FromTemplateFile(file)
+	var terr *TemplateError
+	if !errors.As(err, &terr) || terr.Field != "Bdeps" || terr.Template != file {
+		t.Errorf("Expected an error naming Bdeps of %s, got %v", file, err)
+	}
+
+	file = writeTemplate(t, dir, "name=test\nversion=1.0\niteration=one\n")
+	if _, err = FromTemplateFile(file); !errors.As(err, &terr) || terr.Field != "Iteration" {
+		t.Errorf("Expected an error naming Iteration, got %v", err)
+	}
+
+	file = writeTemplate(t, dir, "echo broken >&2\nexit 3\n")
+	if _, err = FromTemplateFile(file); !errors.As(err, &terr) || terr.Field != "" || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected the template's own error, got %v", err)
+	}
+
+	defer func(timeout time.Duration) { TemplateTimeout = timeout }(TemplateTimeout)
+	TemplateTimeout = 100 * time.Millisecond
+	file = writeTemplate(t, dir, "sleep 10 &\nsleep 10\n")
+	start := time.Now()
+	if _, err = FromTemplateFile(file); err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Timed out template should have been killed")
+	}
+}
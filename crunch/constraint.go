@@ -2,6 +2,7 @@ package crunch
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/cam72cam/go-lumberjack/log"
 	"github.com/serenitylinux/libspack/spdl"
@@ -11,6 +12,30 @@ import (
 type Constraint struct {
 	parent *string //Can't use Node here.  Clone becomes overly complex
 	value  spdl.Dep
+	origin string //Where a site policy constraint was configured, never enables a node
+
+	installed bool //Keeps an already installed package and its flags
+}
+
+func (c Constraint) IsPolicy() bool {
+	return c.origin != ""
+}
+
+//Describes where this constraint came from, for error messages
+func (c Constraint) Source(g *Graph) string {
+	switch {
+	case c.parent != nil:
+		if parent, ok := g.nodes[*c.parent]; ok && parent.pkginfo != nil {
+			return parent.Pkginfo().PrettyString()
+		}
+		return *c.parent
+	case c.IsPolicy():
+		return c.origin
+	case c.installed:
+		return "installed"
+	default:
+		return "requested"
+	}
 }
 
 type Constraints []Constraint
@@ -40,6 +65,41 @@ func (c *Constraints) RemoveParent(parent string) bool {
 	return false
 }
 
+func (c *Constraints) RemoveInstalled() bool {
+	removed := false
+	nc := (*c)[:0]
+	for _, val := range *c {
+		if val.installed {
+			removed = true
+			continue
+		}
+		nc = append(nc, val)
+	}
+	*c = nc
+	return removed
+}
+
+//An explicit request overrides the flags an installed package was built with
+func (c *Constraints) OverrideInstalled(dep spdl.Dep) {
+	if dep.Flags == nil {
+		return
+	}
+	for i, val := range *c {
+		if !val.installed || val.value.Flags == nil {
+			continue
+		}
+		flags := spdl.NewFlagList(len(val.value.Flags.Slice()))
+		for _, flag := range val.value.Flags.Slice() {
+			if _, requested := dep.Flags.Contains(flag.Name); !requested {
+				flags.Add(flag)
+			}
+		}
+		log.Debug.Format(prefix+"Overriding installed flags %v with %v", val.value.Flags.String(), dep.Flags.String())
+		val.value.Flags = &flags
+		(*c)[i] = val
+	}
+}
+
 func (c Constraints) Clone() Constraints {
 	nc := make(Constraints, len(c))
 	for i, val := range c {
@@ -89,14 +149,7 @@ func (c Constraints) Flags(g *Graph) (total spdl.FlatFlagList, err error) {
 				return err
 			}
 			if err := total.Merge(flags); err != nil {
-				for _, c := range c {
-					var parent string
-					if c.parent != nil {
-						parent = g.nodes[*c.parent].Pkginfo().PrettyString()
-					}
-					fmt.Printf("DEP: %s %s\n", parent, c.value.String())
-				}
-				return err
+				return fmt.Errorf("%v, constraints:\n%s", err, c.Describe(g))
 			}
 		}
 		return nil
@@ -122,6 +175,9 @@ func (c Constraints) AnyEnabled(g *Graph) bool {
 		return false
 	}
 	for _, val := range c {
+		if val.IsPolicy() {
+			continue //Site policy only restricts packages that are otherwise needed
+		}
 		if val.value.Condition == nil {
 			return true
 		}
@@ -138,11 +194,20 @@ func (c Constraints) AnyEnabled(g *Graph) bool {
 	return false
 }
 
+func (c Constraints) Describe(g *Graph) string {
+	lines := make([]string, 0, len(c))
+	for _, val := range c {
+		lines = append(lines, fmt.Sprintf("\t%s (%s)", val.value.String(), val.Source(g)))
+	}
+	return strings.Join(lines, "\n")
+}
+
 //TODO Do we want a list of dep.Dep or will flags suffice?
 func (c Constraints) Hash(g *Graph) string {
 	flags, err := c.Flags(g)
 	if err != nil {
-		panic(err)
+		//Conflicts are reported when the node applies its changes
+		return err.Error()
 	}
 	return flags.String()
 }
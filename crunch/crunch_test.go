@@ -2,24 +2,28 @@ package crunch
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
 	"github.com/serenitylinux/libspack/repo"
 	"github.com/serenitylinux/libspack/spdl"
 )
 
-func TestCrunchBasics(t *testing.T) {
-	log.SetLevel(log.DebugLevel)
-
-	loadEntry := func(c string) (e repo.Entry) {
-		err := json.Unmarshal([]byte(c), &e.Control)
-		if err != nil {
-			panic(err)
-		}
-		e.Template = "does_not_exist_" + e.Control.Name + ".pie"
-		return e
+func loadEntry(c string) (e repo.Entry) {
+	err := json.Unmarshal([]byte(c), &e.Control)
+	if err != nil {
+		panic(err)
 	}
+	e.Template = "does_not_exist_" + e.Control.Name + ".pie"
+	return e
+}
+
+func basicRepos() repo.RepoList {
 	A := loadEntry(`
 {
 	"Name": "A",
@@ -56,7 +60,13 @@ func TestCrunchBasics(t *testing.T) {
 	]
 }`)
 
-	g, err := NewGraph("/test_dir_does_not_exist", repo.RepoList{"Test": repo.MockRepo("Test", A, B, C)})
+	return repo.RepoList{"Test": repo.MockRepo("Test", A, B, C)}
+}
+
+func TestCrunchBasics(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	g, err := NewGraph("/test_dir_does_not_exist", basicRepos())
 	if err != nil {
 		t.Error(err)
 	}
@@ -81,3 +91,223 @@ func TestCrunchBasics(t *testing.T) {
 	}
 
 }
+
+func TestCrunchConstraintConfig(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	root, err := ioutil.TempDir("", "crunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(root+"/etc/spack", 0755); err != nil {
+		t.Fatal(err)
+	}
+	conf := "A(+dev)\nB>=1.0.0\n"
+	if err := ioutil.WriteFile(root+"/etc/spack/pkg.conf", []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGraph(root, basicRepos())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.nodes["B"].IsEnabled() {
+		t.Errorf("B should NOT have been enabled by its config constraint")
+	}
+
+	wanted := g.Clone()
+	if err := wanted.EnablePackage(spdl.Dep{Name: "A"}, InstallConvenient); err != nil {
+		t.Fatal(err)
+	}
+	if err := wanted.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if !wanted.nodes["A"].Pkginfo().FlagStates.IsEnabled("dev") {
+		t.Errorf("A should have been built +dev from the config")
+	}
+
+	conflict := g.Clone()
+	flags := spdl.NewFlagList(0)
+	flags.Add(spdl.Flag{Name: "dev", State: spdl.Disabled})
+	err = conflict.EnablePackage(spdl.Dep{Name: "A", Flags: &flags}, InstallConvenient)
+	if err == nil {
+		t.Fatalf("A(-dev) should conflict with the config")
+	}
+	if !strings.Contains(err.Error(), "pkg.conf:1") {
+		t.Errorf("Conflict should name the config line, got: %v", err)
+	}
+
+	//Graphs made after the config changes follow the new one
+	if err := ioutil.WriteFile(root+"/etc/spack/pkg.conf", []byte("A(-dev)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err = NewGraph(root, basicRepos())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.EnablePackage(spdl.Dep{Name: "A", Flags: &flags}, InstallConvenient); err != nil {
+		t.Errorf("A(-dev) should follow the changed config: %v", err)
+	}
+}
+
+func TestCrunchEnableInstalled(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+
+	X := loadEntry(`
+{
+	"Name": "X",
+	"Version": "1.0.0",
+	"Iteration": 1,
+	"Deps": [
+		"[+qt]Q"
+	],
+	"Flags": [
+		"-qt"
+	]
+}`)
+	Q := loadEntry(`
+{
+	"Name": "Q",
+	"Version": "1.0.0",
+	"Iteration": 1
+}`)
+	Y := loadEntry(`
+{
+	"Name": "Y",
+	"Version": "1.0.0",
+	"Iteration": 1,
+	"Deps": [
+		"X(-qt)"
+	]
+}`)
+	repos := repo.RepoList{"Test": repo.MockRepo("Test", X, Q, Y)}
+
+	root, err := ioutil.TempDir("", "crunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	//X is installed built with +qt
+	installDir := root + repo.InstallDir + "Test/"
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := pkginfo.FromControl(&X.Control)
+	if err := p.SetFlagState(spdl.FlatFlag{Name: "qt", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	set := repo.NewPkgIS(&X.Control, p, hash.HashList{}, repo.ReasonExplicit)
+	if err := set.ToFile(installDir + p.String() + ".pkgset"); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGraph(root, repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.EnableInstalled(); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := g.Clone()
+	if err := kept.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if !kept.nodes["Q"].IsEnabled() {
+		t.Errorf("Q should have been enabled for the installed X(+qt)")
+	}
+	if len(kept.ToChange()) != 0 {
+		t.Errorf("Nothing installed should change")
+	}
+
+	removed := g.Clone()
+	if err := removed.DisableInstalled("X"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removed.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if removed.nodes["X"].IsEnabled() || removed.nodes["Q"].IsEnabled() {
+		t.Errorf("Nothing should need X or Q once X is removed")
+	}
+
+	broken := g.Clone()
+	if err := broken.EnablePackage(spdl.Dep{Name: "Y"}, InstallConvenient); err != nil {
+		t.Fatal(err)
+	}
+	err = broken.Crunch()
+	if err == nil {
+		t.Fatalf("Y should not be able to change the installed X(+qt)")
+	}
+	if !strings.Contains(err.Error(), "installed") {
+		t.Errorf("Conflict should name the installed package, got: %v", err)
+	}
+
+	changed := g.Clone()
+	flags := spdl.NewFlagList(0)
+	flags.Add(spdl.Flag{Name: "qt", State: spdl.Disabled})
+	if err := changed.EnablePackage(spdl.Dep{Name: "X", Flags: &flags}, InstallConvenient); err != nil {
+		t.Fatal(err)
+	}
+	if err := changed.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if c := changed.ToChange(); len(c) != 1 || c[0].Name != "X" {
+		t.Errorf("Explicitly requested X(-qt) should replace the installed X(+qt)")
+	}
+}
+
+func TestCrunchRepoPriority(t *testing.T) {
+	entry := func(version string) repo.Entry {
+		return loadEntry(`
+{
+	"Name": "D",
+	"Description": "D's Description",
+	"Version": "` + version + `",
+	"Iteration": 1
+}`)
+	}
+	low := repo.MockRepo("Low", entry("2.0.0"))
+	high := repo.MockRepo("High", entry("1.0.0"))
+	high.Priority = 10
+	repos := repo.RepoList{"Low": low, "High": high}
+
+	g, err := NewGraph("/test_dir_does_not_exist", repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preferred := g.Clone()
+	if err := preferred.EnablePackage(spdl.Dep{Name: "D"}, InstallConvenient); err != nil {
+		t.Fatal(err)
+	}
+	if err := preferred.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if n := preferred.nodes["D"]; n.Repo != high || n.Pkginfo().Version != "1.0.0" {
+		t.Errorf("D should come from the higher priority repo, got %v::%v", n.Repo.Name, n.Pkginfo().PrettyString())
+	}
+
+	pinned := g.Clone()
+	if err := pinned.EnablePackage(spdl.Dep{Repo: "Low", Name: "D"}, InstallConvenient); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinned.Crunch(); err != nil {
+		t.Fatal(err)
+	}
+	if n := pinned.nodes["D"]; n.Repo != low || n.Pkginfo().Version != "2.0.0" {
+		t.Errorf("D should come from the pinned repo, got %v::%v", n.Repo.Name, n.Pkginfo().PrettyString())
+	}
+
+	missing := g.Clone()
+	err = missing.EnablePackage(spdl.Dep{Repo: "Other", Name: "D"}, InstallConvenient)
+	if err == nil {
+		err = missing.Crunch()
+	}
+	if err == nil || !strings.Contains(err.Error(), "Other") {
+		t.Errorf("D should not resolve from a repo that does not provide it, got: %v", err)
+	}
+}
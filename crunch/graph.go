@@ -6,6 +6,7 @@ import (
 	"io"
 
 	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/constraintconfig"
 	"github.com/serenitylinux/libspack/repo"
 	"github.com/serenitylinux/libspack/spdl"
 )
@@ -23,6 +24,7 @@ type Graph struct {
 	root    string
 	ordered []*Node
 	nodes   map[string]*Node
+	repos   []*repo.Repo //By priority
 }
 
 func NewGraph(root string, repos repo.RepoList) (*Graph, error) {
@@ -30,12 +32,15 @@ func NewGraph(root string, repos repo.RepoList) (*Graph, error) {
 		root:    root,
 		ordered: make([]*Node, 0, 100),
 		nodes:   make(map[string]*Node, 100),
+		repos:   repos.Sorted(),
 	}
 
-	for _, r := range repos {
+	//Higher priority repos come first and provide the package unless it is pinned elsewhere
+	for _, r := range g.repos {
 		r.MapWithName(func(name string, _ []repo.Entry) {
 			if curr, ok := g.nodes[name]; ok {
-				log.Warn.Format("Duplicate package name %v::%v, %v::%v", r.Name, name, curr.Repo.Name, curr.Name)
+				log.Debug.Format("Package %v::%v is also provided by %v", curr.Repo.Name, name, r.Name)
+				curr.repos = append(curr.repos, r)
 				return
 			}
 			node := NewNode(name, r, g)
@@ -44,15 +49,40 @@ func NewGraph(root string, repos repo.RepoList) (*Graph, error) {
 		})
 	}
 
+	if err := g.loadConstraintConfig(); err != nil {
+		return nil, err
+	}
+
 	return g, nil
 }
 
+//Apply the site policy from the root's constraint config to every node
+func (g *Graph) loadConstraintConfig() error {
+	list, err := constraintconfig.GetAll(g.root)
+	if err != nil {
+		return fmt.Errorf("Unable to load constraint config: %v", err)
+	}
+
+	for name, c := range list {
+		node, ok := g.nodes[name]
+		if !ok {
+			log.Warn.Format("Constraint for unknown package %v in %v", name, c.Origin())
+			continue
+		}
+		if err := node.AddPolicyConstraint(c.Dep, c.Origin()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (g *Graph) ChangeRoot(root string) {
 	g.root = root
 }
 
 func (g *Graph) EnablePackage(dep spdl.Dep, typ InstallType) error {
 	if curr, ok := g.nodes[dep.Name]; ok {
+		curr.rdeps.OverrideInstalled(dep)
 		if err := curr.SetInstallType(typ); err != nil {
 			return err
 		}
@@ -67,11 +97,40 @@ func (g *Graph) EnablePackage(dep spdl.Dep, typ InstallType) error {
 	return fmt.Errorf("Unable to find package %v", dep.Name)
 }
 
+//Enables every package installed in the graph's root, constrained to the
+//flags it was installed with so new packages can not silently break it
 func (g *Graph) EnableInstalled() error {
-	//TODO
+	for _, r := range g.repos {
+		var interr error
+		err := r.MapInstalled(g.root, func(p repo.PkgInstallSet) {
+			if interr != nil {
+				return
+			}
+			curr, ok := g.nodes[p.PkgInfo.Name]
+			if !ok || !curr.providedBy(r.Name) {
+				log.Warn.Format("Installed package %v::%v is no longer available", r.Name, p.PkgInfo.PrettyString())
+				return
+			}
+			interr = curr.AddInstalledConstraint(*p.PkgInfo, r.Name)
+		})
+		if err != nil {
+			return err
+		}
+		if interr != nil {
+			return interr
+		}
+	}
 	return nil
 }
 
+//Drops an installed package so it is only kept if something else needs it
+func (g *Graph) DisableInstalled(name string) error {
+	if curr, ok := g.nodes[name]; ok {
+		return curr.RemoveInstalledConstraint()
+	}
+	return fmt.Errorf("Unable to find package %v", name)
+}
+
 func (g *Graph) Find(name string) (*Node, bool) {
 	node, ok := g.nodes[name]
 	return node, ok
@@ -96,11 +155,23 @@ func (g *Graph) ToForge() []*Node {
 	return wield
 }
 
+//Installed packages which will be replaced by a different build
+func (g *Graph) ToChange() []*Node {
+	change := make([]*Node, 0)
+	for _, node := range g.ordered {
+		if node.installed != nil && node.IsEnabled() && node.pkginfo != nil && !node.IsInstalled() {
+			change = append(change, node)
+		}
+	}
+	return change
+}
+
 func (g Graph) Clone() *Graph {
 	ng := &Graph{
 		root:    g.root,
 		ordered: make([]*Node, len(g.ordered)),
 		nodes:   make(map[string]*Node, len(g.nodes)),
+		repos:   g.repos,
 	}
 	for i, n := range g.ordered {
 		clone := n.Clone(ng)
@@ -12,10 +12,12 @@ import (
 
 type Node struct {
 	Name  string
-	Repo  *repo.Repo
+	Repo  *repo.Repo //Selected from repos when changes are applied
 	Graph *Graph
 	Type  InstallType
 
+	repos []*repo.Repo //Every repo providing the package, by priority
+
 	rdeps Constraints
 
 	isEnabled         bool
@@ -27,15 +29,19 @@ type Node struct {
 	isInstalled bool
 	isBin       bool
 
+	installed *pkginfo.PkgInfo //Currently installed in the graph's root
+
 	lastHash string
 }
 
-func NewNode(name string, repo *repo.Repo, graph *Graph) *Node {
+func NewNode(name string, r *repo.Repo, graph *Graph) *Node {
 	return &Node{
 		Name:  name,
-		Repo:  repo,
+		Repo:  r,
 		Graph: graph,
 		Type:  InstallConvenient,
+
+		repos: []*repo.Repo{r},
 	}
 }
 
@@ -45,6 +51,8 @@ func (n Node) Clone(newgraph *Graph) *Node {
 		Repo:  n.Repo,
 		Graph: newgraph,
 
+		repos: n.repos,
+
 		rdeps: n.rdeps.Clone(),
 
 		isEnabled:         n.isEnabled,
@@ -55,6 +63,8 @@ func (n Node) Clone(newgraph *Graph) *Node {
 		isInstalled: n.isInstalled,
 		isBin:       n.isBin,
 
+		installed: n.installed,
+
 		lastHash: n.lastHash,
 	}
 }
@@ -75,6 +85,9 @@ func (n *Node) ApplyChanges() error {
 		n.isEnabled = true
 	}()
 
+	if err := n.selectRepo(); err != nil {
+		return err
+	}
 	versions, err := n.rdeps.Versions(n.Graph)
 	if err != nil {
 		return err
@@ -148,8 +161,7 @@ func (n *Node) ApplyChanges() error {
 		}
 		fallthrough
 	default:
-		//TODO better error
-		return fmt.Errorf("Unable to find version of package %v", n.Name)
+		return fmt.Errorf("Unable to find version of package %v matching:\n%s", n.Name, n.rdeps.Describe(n.Graph))
 	}
 }
 
@@ -158,6 +170,24 @@ func (n *Node) AddConstraint(dep spdl.Dep) error {
 	n.hasNewConstraints = true
 	return nil
 }
+func (n *Node) AddPolicyConstraint(dep spdl.Dep, origin string) error {
+	n.rdeps.Add(Constraint{value: dep, origin: origin})
+	return nil
+}
+//Installed packages stay with the repo they came from unless another constraint pins them
+func (n *Node) AddInstalledConstraint(p pkginfo.PkgInfo, repoName string) error {
+	flags := p.FlagStates.ToFlagList()
+	n.rdeps.Add(Constraint{value: spdl.Dep{Repo: repoName, Name: p.Name, Flags: &flags}, installed: true})
+	n.installed = &p
+	n.hasNewConstraints = true
+	return nil
+}
+func (n *Node) RemoveInstalledConstraint() error {
+	if n.rdeps.RemoveInstalled() {
+		n.hasNewConstraints = true
+	}
+	return nil
+}
 func (n *Node) AddParentConstraint(parent string, dep spdl.Dep) error {
 	//TODO GLIBC HACK
 	if n.Name == parent {
@@ -189,6 +219,57 @@ func (n *Node) SetInstallType(typ InstallType) error {
 	return nil
 }
 
+func (n *Node) providedBy(repoName string) bool {
+	for _, r := range n.repos {
+		if r.Name == repoName {
+			return true
+		}
+	}
+	return false
+}
+
+//Picks the repo the constraints pin the node to, or the highest priority one
+func (n *Node) selectRepo() error {
+	var pinned, installed string
+	err := n.rdeps.Map(n.Graph, func(c Constraint, _ spdl.FlatFlagList) error {
+		switch {
+		case c.value.Repo == "":
+		case c.installed:
+			installed = c.value.Repo
+		case pinned != "" && pinned != c.value.Repo:
+			return fmt.Errorf("Package %v is pinned to both %v and %v, constraints:\n%s", n.Name, pinned, c.value.Repo, n.rdeps.Describe(n.Graph))
+		default:
+			pinned = c.value.Repo
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if pinned == "" {
+		pinned = installed
+	}
+	if pinned == "" {
+		n.Repo = n.repos[0]
+		return nil
+	}
+
+	for _, r := range n.repos {
+		if r.Name == pinned {
+			n.Repo = r
+			return nil
+		}
+	}
+	return fmt.Errorf("Package %v is not available from %v, constraints:\n%s", n.Name, pinned, n.rdeps.Describe(n.Graph))
+}
+
+//Dep on exactly the build the node resolved to
+func (n *Node) ToDep() spdl.Dep {
+	dep := n.Pkginfo().ToDep()
+	dep.Repo = n.Repo.Name
+	return dep
+}
+
 func (n *Node) Control() control.Control {
 	return *n.control
 }
@@ -201,6 +282,19 @@ func (n *Node) IsEnabled() bool {
 	return n.rdeps.AnyEnabled(n.Graph)
 }
 
+//The build currently installed in the graph's root, if any
+func (n *Node) Installed() (pkginfo.PkgInfo, bool) {
+	if n.installed == nil {
+		return pkginfo.PkgInfo{}, false
+	}
+	return *n.installed, true
+}
+
+//Why this node resolved to its current build
+func (n *Node) Reason() string {
+	return n.rdeps.Describe(n.Graph)
+}
+
 func (n *Node) IsInstalled() bool {
 	return n.isInstalled
 }
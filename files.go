@@ -0,0 +1,45 @@
+package libspack
+
+import (
+	"fmt"
+
+	"github.com/serenitylinux/libspack/lock"
+	"github.com/serenitylinux/libspack/repo"
+)
+
+//Packages which own path in root
+func Owners(path string, root string) ([]repo.FileOwner, error) {
+	rootLock, err := lock.Shared(root)
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	return repo.Owners(path, root)
+}
+
+//Files installed by package name in root
+func Files(name string, root string) ([]string, error) {
+	rootLock, err := lock.Shared(root)
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	set, r := repo.GetPackageInstalledByName(name, root)
+	if set == nil {
+		return nil, fmt.Errorf("Package %v is not installed", name)
+	}
+	return r.Files(set.PkgInfo, root)
+}
+
+//Files under dir in root which no installed package owns
+func UnownedFiles(dir string, root string) ([]string, error) {
+	rootLock, err := lock.Shared(root)
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	return repo.UnownedFiles(dir, root)
+}
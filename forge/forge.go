@@ -371,7 +371,7 @@ func createSums(destdir string) (HashList, error) {
 
 	walkFunc := func(path string, f os.FileInfo, err error) (erri error) {
 		if !f.IsDir() {
-			sum, erri := Md5sum(path)
+			sum, erri := File(path)
 			if erri == nil {
 				log.Debug.Format("%s:\t%s", sum, path)
 				hl[path] = sum
@@ -392,6 +392,8 @@ func createPkgInstall(template string) (string, error) {
 source %s
 declare -f pre_install
 declare -f post_install
+declare -f pre_remove
+declare -f post_remove
 exit 0
 `, template)
 	err := RunCommand(exec.Command("bash", "-c", bashStr), buf, os.Stderr)
@@ -439,10 +441,10 @@ func addFsToSpakg(dir, outfile string, archive spakg.Spakg) error {
 func BuildPackage(info forgeInfo) error {
 	Header("Building package")
 
-	//Md5Sums
+	//Hashes
 	hl, err := createSums(info.root + info.workdir + dest)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Unable to generate md5sums: %s", err))
+		return errors.New(fmt.Sprintf("Unable to generate hashes: %s", err))
 	}
 
 	pi := pkginfo.FromControl(&info.control)
@@ -465,7 +467,7 @@ func BuildPackage(info forgeInfo) error {
 	}
 
 	//Create Spakg
-	archive := spakg.Spakg{Md5sums: hl, Control: info.control, Template: templateStr, Pkginfo: *pi, Pkginstall: pkginstall}
+	archive := spakg.Spakg{Hashes: hl, Control: info.control, Template: templateStr, Pkginfo: *pi, Pkginstall: pkginstall}
 	//FS
 	err = addFsToSpakg(info.root+info.workdir, info.outfile, archive)
 	if err != nil {
@@ -1,24 +1,117 @@
 package hash
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
+	gohash "hash"
 	"io"
 	"os"
+	"strings"
 )
 
-func Md5sum(filename string) (string, error) {
-	h := md5.New()
+//Hashes are stored as "algorithm:hex", bare hex is an md5 from before hashes were tagged
+type Algorithm string
+
+const (
+	MD5    Algorithm = "md5"
+	SHA256 Algorithm = "sha256"
+)
+
+//Algorithm used for newly created hashes
+var Default = SHA256
+
+func (a Algorithm) new() (gohash.Hash, error) {
+	switch a {
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("Unknown hash algorithm %v", a)
+	}
+}
+
+//Splits a hash into its algorithm and hex digest
+func Split(sum string) (Algorithm, string) {
+	if i := strings.Index(sum, ":"); i >= 0 {
+		return Algorithm(sum[:i]), strings.ToLower(sum[i+1:])
+	}
+	return MD5, strings.ToLower(sum)
+}
+
+func sumReader(a Algorithm, r io.Reader) (string, error) {
+	h, err := a.new()
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v:%x", a, h.Sum(nil)), nil
+}
+
+//Hashes filename with a, tagged with the algorithm
+func Sum(a Algorithm, filename string) (string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return "", err
 	}
+	defer file.Close()
+	return sumReader(a, file)
+}
+
+func SumBytes(a Algorithm, data []byte) (string, error) {
+	return sumReader(a, bytes.NewReader(data))
+}
+
+//Hashes filename with the default algorithm
+func File(filename string) (string, error) {
+	return Sum(Default, filename)
+}
+
+//Hashes filename with the same algorithm as expected, so the two can be compared
+func SumAs(expected string, filename string) (string, error) {
+	a, _ := Split(expected)
+	return Sum(a, filename)
+}
+
+//Whether two hashes are the same, a bare md5 equals its tagged form
+func Equal(a, b string) bool {
+	aAlg, aHex := Split(a)
+	bAlg, bHex := Split(b)
+	return aAlg == bAlg && aHex == bHex
+}
 
-	_, err = io.Copy(h, file)
+//Whether filename still hashes to expected
+func Verify(filename string, expected string) (bool, error) {
+	sum, err := SumAs(expected, filename)
+	if err != nil {
+		return false, err
+	}
+	return Equal(sum, expected), nil
+}
+
+//Whether data hashes to expected
+func VerifyBytes(data []byte, expected string) (bool, error) {
+	a, _ := Split(expected)
+	sum, err := SumBytes(a, data)
+	if err != nil {
+		return false, err
+	}
+	return Equal(sum, expected), nil
+}
+
+//Bare md5 hex of filename, kept for callers predating tagged hashes
+func Md5sum(filename string) (string, error) {
+	sum, err := Sum(MD5, filename)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	_, hex := Split(sum)
+	return hex, nil
 }
 
 type HashList map[string]string
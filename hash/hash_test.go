@@ -0,0 +1,45 @@
+package hash
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	file, err := ioutil.TempFile("", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("hello")
+	file.Close()
+
+	sum, err := File(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sum, "sha256:") {
+		t.Errorf("New hashes should be sha256, got %v", sum)
+	}
+	if ok, err := Verify(file.Name(), sum); err != nil || !ok {
+		t.Errorf("%v should verify: %v", sum, err)
+	}
+
+	//Written by versions which only knew md5
+	legacy := "5d41402abc4b2a76b9719d911017c592"
+	if ok, err := Verify(file.Name(), legacy); err != nil || !ok {
+		t.Errorf("Bare md5 %v should verify: %v", legacy, err)
+	}
+	if !Equal(legacy, "md5:"+legacy) {
+		t.Errorf("Bare md5 should equal its tagged form")
+	}
+	if Equal(legacy, sum) {
+		t.Errorf("Hashes with different algorithms are not equal")
+	}
+
+	if _, err := Verify(file.Name(), "crc32:abcd"); err == nil {
+		t.Errorf("Unknown algorithms should be refused")
+	}
+}
@@ -1,11 +1,17 @@
 package git
 
 import (
-	"github.com/serenitylinux/libspack/misc"
-	"os"
 	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/misc"
 )
 
+//Full or abbreviated commit ids, which not every server lets us fetch directly
+var commitRegex = regexp.MustCompile("^[0-9a-f]{4,40}$")
+
 func Clone(url string, dir string) (err error) {
 	ioerr := misc.InDir(dir, func() {
 		err = misc.RunCommandToStdOutErr(exec.Command("git", "clone", url, "."))
@@ -16,21 +22,76 @@ func Clone(url string, dir string) (err error) {
 	return
 }
 
-func Update(url string, dir string) (err error) {
-	ioerr := misc.InDir(dir, func() {
-		err = misc.RunCommandToStdOutErr(exec.Command("git", "pull"))
-	})
-	if ioerr != nil {
-		return ioerr
+//Points origin at url and checks out exactly ref (branch, tag or commit), the
+//remote's HEAD if ref is empty. Returns the commit checked out
+func Update(url string, dir string, ref string) (string, error) {
+	if err := setOrigin(url, dir); err != nil {
+		return "", err
 	}
-	return
+
+	target, err := fetch(dir, ref)
+	if err != nil {
+		return "", err
+	}
+	//Detached so local branches can't drift from the remote, forced over any local edits
+	if err := run(dir, "checkout", "--quiet", "--force", "--detach", target); err != nil {
+		return "", err
+	}
+	return output(dir, "rev-parse", "HEAD")
+}
+
+func CloneOrUpdate(url string, dir string, ref string) (string, error) {
+	//If repo does not exist
+	if !misc.PathExists(dir + "/.git") {
+		if err := run(dir, "init", "--quiet"); err != nil {
+			return "", err
+		}
+	}
+	return Update(url, dir, ref)
 }
 
-func CloneOrUpdate(url string, dir string) error {
-	//If repo exists
-	if _, err := os.Stat(dir + "/.git"); err == nil {
-		return Update(url, dir)
-	} else {
-		return Clone(url, dir)
+func setOrigin(url string, dir string) error {
+	//Fails if there is no origin yet
+	curr, err := output(dir, "config", "--get", "remote.origin.url")
+	switch {
+	case err != nil:
+		return run(dir, "remote", "add", "origin", url)
+	case curr != url:
+		log.Info.Format("Remote of %s changed from %s to %s", dir, curr, url)
+		return run(dir, "remote", "set-url", "origin", url)
+	}
+	return nil
+}
+
+//Returns what to check out for ref once fetched
+func fetch(dir string, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	err := run(dir, "fetch", "--quiet", "--force", "origin", ref)
+	if err == nil {
+		return "FETCH_HEAD", nil
+	}
+	if !commitRegex.MatchString(ref) {
+		return "", err
 	}
+
+	log.Debug.Format("Unable to fetch commit %s directly, fetching all branches", ref)
+	if err := run(dir, "fetch", "--quiet", "--force", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+func run(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return misc.RunCommandToStdOutErr(cmd)
+}
+
+func output(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := misc.RunCommandToString(cmd)
+	return strings.TrimSpace(out), err
 }
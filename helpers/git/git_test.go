@@ -0,0 +1,115 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func gitTest(t *testing.T, dir string, args ...string) string {
+	out, err := output(dir, args...)
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return out
+}
+
+//Commits content as the file "a", returning the commit
+func commitTest(t *testing.T, dir string, content string) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitTest(t, dir, "add", "a")
+	gitTest(t, dir, "commit", "--quiet", "-m", content)
+	return gitTest(t, dir, "rev-parse", "HEAD")
+}
+
+func checkTest(t *testing.T, dir string, commit string, content string) {
+	if head := gitTest(t, dir, "rev-parse", "HEAD"); head != commit {
+		t.Errorf("Expected %s checked out, got %s", commit, head)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "a")); err != nil || string(data) != content {
+		t.Errorf("Expected %q in the work tree, got %q (%v)", content, data, err)
+	}
+}
+
+func TestCloneOrUpdate(t *testing.T) {
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "test")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "test@example.com")
+	}
+
+	tmp, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src.git")
+	other := filepath.Join(tmp, "other.git")
+	dir := filepath.Join(tmp, "dir")
+	for _, d := range []string{src, other, dir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gitTest(t, src, "init", "--quiet", "--initial-branch=main")
+	first := commitTest(t, src, "1")
+	gitTest(t, src, "tag", "v1")
+	gitTest(t, src, "checkout", "--quiet", "-b", "dev")
+	dev := commitTest(t, src, "dev")
+	gitTest(t, src, "checkout", "--quiet", "main")
+	second := commitTest(t, src, "2")
+
+	commit, err := CloneOrUpdate(src, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != second {
+		t.Errorf("Expected the remote's HEAD %s, got %s", second, commit)
+	}
+	checkTest(t, dir, second, "2")
+
+	for _, c := range []struct{ ref, commit, content string }{
+		{"v1", first, "1"},
+		{"dev", dev, "dev"},
+		{first, first, "1"},
+		{first[:7], first, "1"},
+		{"main", second, "2"},
+	} {
+		commit, err := CloneOrUpdate(src, dir, c.ref)
+		if err != nil {
+			t.Fatalf("%s: %v", c.ref, err)
+		}
+		if commit != c.commit {
+			t.Errorf("%s: expected %s, got %s", c.ref, c.commit, commit)
+		}
+		checkTest(t, dir, c.commit, c.content)
+	}
+
+	//Branches move with the remote
+	third := commitTest(t, src, "3")
+	if _, err := CloneOrUpdate(src, dir, "main"); err != nil {
+		t.Fatal(err)
+	}
+	checkTest(t, dir, third, "3")
+
+	//Unrelated history from a new remote replaces the old one
+	gitTest(t, other, "init", "--quiet", "--initial-branch=main")
+	unrelated := commitTest(t, other, "other")
+	if _, err := CloneOrUpdate(other, dir, "main"); err != nil {
+		t.Fatal(err)
+	}
+	checkTest(t, dir, unrelated, "other")
+	if url := gitTest(t, dir, "config", "--get", "remote.origin.url"); url != other {
+		t.Errorf("Expected origin %s, got %s", other, url)
+	}
+
+	if _, err := CloneOrUpdate(other, dir, "missing"); err == nil {
+		t.Errorf("A missing ref should fail")
+	}
+}
@@ -1,36 +1,330 @@
 package http
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	gohttp "net/http"
 	"os"
-	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/progress"
+)
+
+const (
+	DefaultRetries     = 3
+	DefaultBackoff     = time.Second
+	DefaultIdleTimeout = time.Minute
+	PartialSuffix      = ".part"      //Unfinished downloads, resumed by the next fetch
+	ValidatorSuffix    = ".validator" //ETag or Last-Modified of the file a partial download belongs to
 )
 
-func HttpFetchFileProgress(url string, outFile string, stdout bool) (err error) {
-	/*
-		out, err := os.Create(outFile)
-		defer out.Close()
+//Used when Options.Client is nil. There is no overall timeout as spakgs can be
+//large, stalled transfers are caught by the idle timeout instead
+var DefaultClient = &gohttp.Client{
+	Transport: &gohttp.Transport{
+		Proxy:                 gohttp.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+//Called as data arrives, total is -1 when the server does not say.
+//Called once more with done == total when the transfer ends, however it ends
+type Progress func(done int64, total int64)
+
+type Options struct {
+	Size     int64  //Expected size, 0 if unknown
+	Hash     string //Expected tagged hash, empty to skip the check
+	Retries  int
+	Backoff  time.Duration //Wait before the first retry, doubled after each
+	Progress Progress      //May be nil
+	Client   *gohttp.Client //DefaultClient if nil
+	Idle     time.Duration  //Gives up on a transfer with no data for this long, DefaultIdleTimeout if 0
+}
+
+func DefaultOptions(stdout bool) Options {
+	return Options{
+		Retries:  DefaultRetries,
+		Backoff:  DefaultBackoff,
+		Progress: ProgressBar(stdout),
+	}
+}
+
+//Progress drawn as a bar on stdout, nil if !stdout
+func ProgressBar(stdout bool) Progress {
+	if !stdout {
+		return nil
+	}
+	bar := progress.NewBar(stdout)
+	return bar.Update
+}
+
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("Server responded to %s: %s", e.url, e.status)
+}
+
+//Client errors will not go away by asking again
+func retryable(err error) bool {
+	if se, ok := err.(statusError); ok {
+		return se.code >= 500 || se.code == gohttp.StatusRequestTimeout || se.code == gohttp.StatusTooManyRequests
+	}
+	return true
+}
+
+//The server does not have the file, which says nothing about the server itself
+func IsNotFound(err error) bool {
+	se, ok := err.(statusError)
+	return ok && (se.code == gohttp.StatusNotFound || se.code == gohttp.StatusGone)
+}
+
+func HttpFetchFileProgress(url string, outFile string, stdout bool) error {
+	return Fetch(url, outFile, DefaultOptions(stdout))
+}
+
+//Downloads url into outFile, which only appears once the whole file has arrived and checks out
+func Fetch(url string, outFile string, opts Options) error {
+	if opts.Client == nil {
+		opts.Client = DefaultClient
+	}
+	if opts.Idle == 0 {
+		opts.Idle = DefaultIdleTimeout
+	}
+	tmp := outFile + PartialSuffix
+	backoff := opts.Backoff
+
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt != 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var resumed bool
+		resumed, err = fetchPartial(url, tmp, opts)
+		if err == nil {
+			err = check(tmp, opts)
+			if err == nil {
+				os.Remove(tmp + ValidatorSuffix)
+				return os.Rename(tmp, outFile)
+			}
+			removePartial(tmp)
+			if !resumed {
+				//A fresh copy which does not match will not match next time either
+				return err
+			}
+			//Whatever we resumed from was bad, start over
+			continue
+		}
+		if !retryable(err) {
+			break
+		}
+	}
+	return err
+}
+
+func check(file string, opts Options) error {
+	if opts.Size > 0 {
+		fi, err := os.Stat(file)
 		if err != nil {
-			return
+			return err
 		}
-		response, err := http.Get(url)
+		if fi.Size() != opts.Size {
+			return fmt.Errorf("Size of %s does not match. Expected %d, got %d", file, opts.Size, fi.Size())
+		}
+	}
+	if opts.Hash != "" {
+		ok, err := hash.Verify(file, opts.Hash)
 		if err != nil {
-			return
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Sum of %s does not match, expected %s", file, opts.Hash)
 		}
-		defer response.Body.Close()
+	}
+	return nil
+}
+
+func removePartial(tmp string) {
+	os.Remove(tmp)
+	os.Remove(tmp + ValidatorSuffix)
+}
+
+//Continues the download in tmp from where it left off, resumed if part of it was already there.
+//A partial file is only resumed if the expected size or hash will catch a stale one, or the
+//server confirms through If-Range that it is still serving the same file
+func fetchPartial(url string, tmp string, opts Options) (bool, error) {
+	var offset int64
+	if fi, err := os.Stat(tmp); err == nil {
+		offset = fi.Size()
+	}
+	var validator string
+	if data, err := ioutil.ReadFile(tmp + ValidatorSuffix); err == nil {
+		validator = strings.TrimSpace(string(data))
+	}
+	if offset > 0 && ((opts.Size == 0 && opts.Hash == "" && validator == "") || (opts.Size > 0 && offset > opts.Size)) {
+		//Nothing can tell whether the partial file is still part of the same file
+		removePartial(tmp)
+		offset = 0
+	}
+	if opts.Size > 0 && offset == opts.Size {
+		return true, nil
+	}
 
-		if response.StatusCode != 200 {
-			err = errors.New("Server responded: " + response.Status)
-			return
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(opts.Idle, cancel)
+	defer idle.Stop()
+
+	req, err := gohttp.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
 		}
-		pb := progress.NewProgress(os.Stderr, response.ContentLength, stdout)
+	}
+
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return offset > 0, err
+	}
+	defer resp.Body.Close()
 
-		io.Copy(pb, response.Body)
-		if stdout {
-			fmt.Println()
+	restart := func() (bool, error) {
+		resp.Body.Close()
+		removePartial(tmp)
+		return fetchPartial(url, tmp, opts)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == gohttp.StatusPartialContent && offset > 0:
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return restart()
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == gohttp.StatusOK:
+		//Range ignored or the file changed, the whole file is coming again
+		offset = 0
+		flags |= os.O_TRUNC
+		if err := saveValidator(tmp, resp); err != nil {
+			return false, err
 		}
-	*/
-	cmd := exec.Command("curl", "-L", url, "-o", outFile, "-#")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	case resp.StatusCode == gohttp.StatusRequestedRangeNotSatisfiable && offset > 0:
+		//Only complete if the file is exactly as long as what we have
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total == offset {
+			return true, nil
+		}
+		return restart()
+	default:
+		return offset > 0, statusError{url, resp.Status, resp.StatusCode}
+	}
+
+	out, err := os.OpenFile(tmp, flags, 0644)
+	if err != nil {
+		return offset > 0, err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	var w io.Writer = out
+	if opts.Progress != nil {
+		pw := &progressWriter{out, offset, total, opts.Progress}
+		defer func() { pw.progress(pw.done, pw.done) }()
+		w = pw
+	}
+
+	_, err = io.Copy(w, &idleReader{resp.Body, idle, opts.Idle})
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return offset > 0, err
+}
+
+//Remembers what identifies the file being downloaded, for If-Range when resuming
+func saveValidator(tmp string, resp *gohttp.Response) error {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		//If-Range needs a strong validator
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(tmp + ValidatorSuffix)
+		return nil
+	}
+	return ioutil.WriteFile(tmp+ValidatorSuffix, []byte(validator), 0644)
+}
+
+//Parses "bytes start-end/total" or "bytes */total", -1 for unknown parts
+func parseContentRange(header string) (start int64, total int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	start, total = -1, -1
+	var err error
+	if parts[1] != "*" {
+		if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if parts[0] != "*" {
+		rng := strings.SplitN(parts[0], "-", 2)
+		if start, err = strconv.ParseInt(rng[0], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+//Pushes the idle timeout back whenever data arrives
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	progress Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.progress(p.done, p.total)
+	return n, err
 }
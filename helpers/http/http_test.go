@@ -0,0 +1,209 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	gohttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var content = []byte(strings.Repeat("spakg content ", 1000))
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func testOptions() Options {
+	return Options{Retries: 3, Backoff: time.Millisecond}
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		gohttp.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+
+	var lastDone, lastTotal int64
+	opts := testOptions()
+	opts.Size = int64(len(content))
+	opts.Progress = func(done, total int64) { lastDone, lastTotal = done, total }
+	if err := Fetch(server.URL, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(out); !bytes.Equal(data, content) {
+		t.Errorf("Fetched content does not match")
+	}
+	if lastDone != int64(len(content)) || lastTotal != int64(len(content)) {
+		t.Errorf("Unexpected progress %v/%v", lastDone, lastTotal)
+	}
+
+	//Resumes what is already there
+	os.Remove(out)
+	ioutil.WriteFile(out+PartialSuffix, content[:100], 0644)
+	if err := Fetch(server.URL, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(out); !bytes.Equal(data, content) {
+		t.Errorf("Resumed content does not match")
+	}
+	if lastDone != int64(len(content)) {
+		t.Errorf("Resumed progress should count what was already there, got %v", lastDone)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		requests++
+		if requests == 1 {
+			//Cut off half way
+			w.Header().Set("Content-Length", "14000")
+			w.Write(content[:500])
+			return
+		}
+		if requests == 2 {
+			w.WriteHeader(gohttp.StatusServiceUnavailable)
+			return
+		}
+		gohttp.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+
+	if err := Fetch(server.URL, out, testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %v", requests)
+	}
+	if data, _ := ioutil.ReadFile(out); !bytes.Equal(data, content) {
+		t.Errorf("Fetched content does not match")
+	}
+}
+
+func TestFetchChecks(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		requests++
+		if r.URL.Path == "/missing" {
+			gohttp.NotFound(w, r)
+			return
+		}
+		gohttp.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+
+	opts := testOptions()
+	opts.Hash = "sha256:0000"
+	if err := Fetch(server.URL, out, opts); err == nil {
+		t.Errorf("Mismatched hash should fail")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("Mismatched file should not be left in place")
+	}
+	if _, err := os.Stat(out + PartialSuffix); !os.IsNotExist(err) {
+		t.Errorf("Mismatched partial file should be removed")
+	}
+
+	opts = testOptions()
+	opts.Size = 10
+	if err := Fetch(server.URL, out, opts); err == nil {
+		t.Errorf("Mismatched size should fail")
+	}
+
+	requests = 0
+	if err := Fetch(server.URL+"/missing", out, testOptions()); err == nil {
+		t.Errorf("Missing file should fail")
+	}
+	if requests != 1 {
+		t.Errorf("Not found should not be retried, got %v requests", requests)
+	}
+}
+
+func TestFetchStalePartial(t *testing.T) {
+	etag := `"v2"`
+	server := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set("ETag", etag)
+		gohttp.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+
+	fetch := func(name string, part []byte, validator string) {
+		os.Remove(out)
+		ioutil.WriteFile(out+PartialSuffix, part, 0644)
+		os.Remove(out + PartialSuffix + ValidatorSuffix)
+		if validator != "" {
+			ioutil.WriteFile(out+PartialSuffix+ValidatorSuffix, []byte(validator), 0644)
+		}
+		//Like packages.list, nothing is known about the file up front
+		if err := Fetch(server.URL, out, testOptions()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if data, _ := ioutil.ReadFile(out); !bytes.Equal(data, content) {
+			t.Errorf("%s: fetched content does not match", name)
+		}
+		if _, err := os.Stat(out + PartialSuffix + ValidatorSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s: validator should be removed once done", name)
+		}
+	}
+
+	stale := bytes.Repeat([]byte("old "), len(content))
+	fetch("larger stale part", stale, "")
+	fetch("smaller stale part", stale[:100], "")
+	fetch("changed file", stale[:100], `"v1"`)
+	fetch("same file", content[:100], etag)
+	fetch("complete part", content, etag)
+}
+
+func TestFetchContentRange(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		requests++
+		if r.Header.Get("Range") != "" {
+			//Answers with a different range than asked for
+			w.Header().Set("Content-Range", "bytes 0-9/"+strconv.Itoa(len(content)))
+			w.WriteHeader(gohttp.StatusPartialContent)
+			w.Write(content[:10])
+			return
+		}
+		gohttp.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+
+	ioutil.WriteFile(out+PartialSuffix, content[:100], 0644)
+	opts := testOptions()
+	opts.Size = int64(len(content))
+	if err := Fetch(server.URL, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(out); !bytes.Equal(data, content) {
+		t.Errorf("Fetched content does not match")
+	}
+	if requests != 2 {
+		t.Errorf("Expected a restart after the bad range, got %v requests", requests)
+	}
+}
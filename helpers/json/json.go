@@ -1,10 +1,13 @@
 package json
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/serenitylinux/libspack/misc"
 )
 
 func DecodeReader(reader io.Reader, item interface{}) error {
@@ -26,14 +29,13 @@ func EncodeWriter(writer io.Writer, item interface{}) error {
 	return enc.Encode(item)
 }
 
+//Written to a temporary file first so readers never see a partial file
 func EncodeFile(file string, item interface{}) error {
-	writer, err := os.Create(file)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := EncodeWriter(&buf, item); err != nil {
 		return err
 	}
-	defer writer.Close()
-
-	return EncodeWriter(writer, item)
+	return misc.WriteFileAtomic(file, &buf)
 }
 
 func Stringify(o interface{}) string {
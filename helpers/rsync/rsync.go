@@ -0,0 +1,22 @@
+package rsync
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/serenitylinux/libspack/misc"
+)
+
+//Makes dir a copy of remote, files gone from remote are removed from dir
+func Sync(remote string, dir string, exclude ...string) error {
+	args := []string{"-rlt", "--delete"}
+	for _, pattern := range exclude {
+		args = append(args, "--exclude", pattern)
+	}
+	args = append(args, strings.TrimSuffix(remote, "/")+"/", dir)
+	return misc.RunCommandToStdOutErr(exec.Command("rsync", args...))
+}
+
+func Fetch(src string, outFile string) error {
+	return misc.RunCommandToStdOutErr(exec.Command("rsync", "-t", src, outFile))
+}
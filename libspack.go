@@ -11,25 +11,28 @@ import (
 	"github.com/serenitylinux/libspack/control"
 	"github.com/serenitylinux/libspack/crunch"
 	"github.com/serenitylinux/libspack/forge"
+	"github.com/serenitylinux/libspack/lock"
 	"github.com/serenitylinux/libspack/misc"
 	"github.com/serenitylinux/libspack/pkginfo"
 	"github.com/serenitylinux/libspack/repo"
 	"github.com/serenitylinux/libspack/spakg"
 	"github.com/serenitylinux/libspack/spdl"
+	"github.com/serenitylinux/libspack/transaction"
 	"github.com/serenitylinux/libspack/wield"
 )
 
 //TODO: reinstall
 
 func Forge(pkgs []spdl.Dep, root string, ignoreBDeps, buildLocal bool) error {
-	return buildGraphs(pkgs, true, root, ignoreBDeps, buildLocal, false, false, crunch.InstallConvenient)
+	return buildGraphs(pkgs, true, root, ignoreBDeps, buildLocal, false, false, false, crunch.InstallConvenient)
 }
 
-func Wield(pkgs []spdl.Dep, root string, reinstall, ignoreDeps bool, itype crunch.InstallType) error {
-	return buildGraphs(pkgs, false, root, false, false, ignoreDeps, reinstall, itype)
+//force overwrites files owned by other installed packages
+func Wield(pkgs []spdl.Dep, root string, reinstall, ignoreDeps, force bool, itype crunch.InstallType) error {
+	return buildGraphs(pkgs, false, root, false, false, ignoreDeps, reinstall, force, itype)
 }
 
-func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, buildLocal bool, ignoreDeps bool, reinstall bool, itype crunch.InstallType) error {
+func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, buildLocal bool, ignoreDeps bool, reinstall bool, force bool, itype crunch.InstallType) error {
 	type forgeInfo struct {
 		Graph *crunch.Graph
 		Root  string
@@ -40,6 +43,16 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 		Template string
 	}
 
+	rootLock, err := lock.Exclusive(root)
+	if err != nil {
+		return err
+	}
+	defer rootLock.Release()
+
+	if err := recoverRoot(root); err != nil {
+		return err
+	}
+
 	graph, err := crunch.NewGraph(root, repo.GetAllRepos())
 	if err != nil {
 		return fmt.Errorf("Unable to load package graph: %v", err.Error())
@@ -70,7 +83,7 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 			info.Graph.ChangeRoot(info.Root)
 		}
 
-		info.Repo, err = repo.GetRepoFor(pkg.Name)
+		info.Repo, err = repo.GetRepoForDep(pkg)
 		if err != nil {
 			return err
 		}
@@ -150,8 +163,6 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 	//toFetch := make([]pkginfo.PkgInfo)
 	toWield := graph.Clone()
 
-	//temporary hack for now
-	//TODO maintain full list of packages manually installed in a root
 	err = toWield.EnableInstalled()
 	if err != nil {
 		return fmt.Errorf("Unable to load currently installed packges: %v", err.Error())
@@ -175,7 +186,7 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 		}
 
 		for _, node := range g.ToForge() {
-			err := addToForge(node.Pkginfo().ToDep())
+			err := addToForge(node.ToDep())
 			if err != nil {
 				return err
 			}
@@ -203,28 +214,58 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 	if len(toForge) != 0 {
 		fmt.Println(color.White.String("Packages to Forge:"))
 		for _, info := range toForge {
-			fmt.Println(info.Pkginfo.PrettyString())
+			fmt.Println(info.Repo.Name + spdl.RepoSeparator + info.Pkginfo.PrettyString())
 			if len(info.Graph.ToWield()) != 0 {
 				fmt.Println(color.White.String("Packages to Wield for ", info.Pkginfo.PrettyString()))
 				for _, pkg := range sortp(info.Graph.ToWield()) {
-					fmt.Println("\t" + pkg.Pkginfo().PrettyString())
+					fmt.Println("\t" + prettyNode(pkg))
 				}
 			}
 		}
 	}
 
-	if len(toWield.ToWield()) != 0 {
+	if len(toWield.ToChange()) != 0 {
+		fmt.Println(color.White.String("Installed packages to change:"))
+		for _, pkg := range toWield.ToChange() {
+			old, _ := pkg.Installed()
+			fmt.Printf("%s -> %s\n", old.PrettyString(), prettyNode(pkg))
+			fmt.Println(pkg.Reason())
+		}
+	}
+
+	//Changes were listed above
+	changing := make(map[string]bool)
+	for _, pkg := range toWield.ToChange() {
+		changing[pkg.Name] = true
+	}
+	newPkgs := make([]*crunch.Node, 0)
+	for _, pkg := range toWield.ToWield() {
+		if !changing[pkg.Name] {
+			newPkgs = append(newPkgs, pkg)
+		}
+	}
+	if len(newPkgs) != 0 {
 		fmt.Println(color.White.String("Packages to Wield:"))
-		for _, pkg := range toWield.ToWield() {
-			fmt.Println(pkg.Pkginfo().PrettyString())
+		for _, pkg := range newPkgs {
+			fmt.Println(prettyNode(pkg))
 		}
 	}
 
 	if len(toWield.ToWield()) == 0 && len(toForge) == 0 {
 		log.Info.Println("Nothing to do")
+		if !isForge {
+			return addToWorld(pkgs, root)
+		}
 		return nil
 	}
 
+	//Caught again once the spakgs are fetched, but better before asking
+	if !force {
+		if err := checkPlanCollisions(toWield.ToWield(), root); err != nil {
+			return err
+		}
+	}
+
 	if !misc.AskYesNo("Do you wish to continue?", true) {
 		return nil
 	}
@@ -241,7 +282,7 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 			}
 
 			if len(info.Graph.ToWield()) != 0 {
-				if err := wieldGraph(info.Graph.ToWield(), info.Root); err != nil {
+				if err := wieldGraph(info.Graph.ToWield(), info.Root, repo.ReasonBuildDependency, nil, false); err != nil {
 					return err
 				}
 			}
@@ -256,22 +297,45 @@ func buildGraphs(pkgs []spdl.Dep, isForge bool, root string, ignoreBDeps bool, b
 	}
 
 	if len(toWield.ToWield()) != 0 {
-		err := wieldGraph(toWield.ToWield(), root)
+		err := wieldGraph(toWield.ToWield(), root, repo.ReasonDependency, pkgs, force)
 		if err != nil {
 			return err
 		}
 	}
 
+	if !isForge {
+		if err := addToWorld(pkgs, root); err != nil {
+			return err
+		}
+	}
+
 	misc.PrintSuccess()
 
 	return nil
 }
 
-func wieldGraph(nodes []*crunch.Node, root string) error {
+//Checks collisions between the packages of a plan using the file lists their
+//repos publish, packages that still need to be forged are skipped
+func checkPlanCollisions(nodes []*crunch.Node, root string) error {
+	pkgs := make([]repo.Incoming, 0, len(nodes))
+	for _, node := range nodes {
+		files, ok := node.Repo.FileList(node.Pkginfo())
+		if !ok {
+			log.Debug.Format("No file list for %s, checking it once fetched", prettyNode(node))
+			continue
+		}
+		pkgs = append(pkgs, repo.Incoming{Control: node.Control(), Pkginfo: node.Pkginfo(), Files: files})
+	}
+	return repo.CheckIncoming(pkgs, root, false)
+}
+
+//Packages named in explicit are installed as explicit, the rest with reason
+func wieldGraph(nodes []*crunch.Node, root string, reason repo.InstallReason, explicit []spdl.Dep, force bool) error {
 	type pkgset struct {
-		spkg *spakg.Spakg
-		repo *repo.Repo
-		file string
+		spkg   *spakg.Spakg
+		repo   *repo.Repo
+		file   string
+		reason repo.InstallReason
 	}
 	spkgs := make([]pkgset, 0)
 
@@ -289,38 +353,105 @@ func wieldGraph(nodes []*crunch.Node, root string) error {
 			return err
 		}
 
-		spkgs = append(spkgs, pkgset{spkg, pkg.Repo, pkgfile})
+		pkgreason := reason
+		for _, dep := range explicit {
+			if dep.Name == pkg.Name {
+				pkgreason = repo.ReasonExplicit
+			}
+		}
+
+		spkgs = append(spkgs, pkgset{spkg, pkg.Repo, pkgfile, pkgreason})
 	}
 	log.Info.Println()
 
-	//Install
+	//Before anything is touched
+	all := make([]*spakg.Spakg, 0, len(spkgs))
 	for _, pkg := range spkgs {
-		err := wield.ExtractCheckCopy(pkg.file, root)
+		all = append(all, pkg.spkg)
+	}
+	if err := repo.CheckCollisions(all, root, force); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
+	tx, err := transaction.Begin(root)
+	if err != nil {
+		return err
+	}
+
+	install := func() error {
+		//Install
+		for _, pkg := range spkgs {
+			//Collisions were checked for the whole set above, one at a time
+			//would reject files moving between packages in the set
+			err := wield.ExtractCheckCopy(pkg.file, root, tx)
+			if err != nil {
+				return err
+			}
+
+			err = pkg.repo.InstallSpakg(pkg.spkg, root, pkg.reason, tx)
+			if err != nil {
+				return err
+			}
+		}
+		log.Debug.Println()
+		if len(spkgs) != 0 {
+			wield.Ldconfig(root)
+		}
+
+		//Preinstall
+		for _, pkg := range spkgs {
+			if err := wield.PreInstall(pkg.spkg, root); err != nil {
+				return err
+			}
 		}
+		log.Debug.Println()
 
-		pkg.repo.InstallSpakg(pkg.spkg, root)
+		//PostInstall
+		for _, pkg := range spkgs {
+			if err := wield.PostInstall(pkg.spkg, root); err != nil {
+				return err
+			}
+		}
+		log.Info.Println()
+		return nil
 	}
-	log.Debug.Println()
-	if len(spkgs) != 0 {
+
+	if err := install(); err != nil {
+		log.Error.Format("Install failed, rolling back: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Error.Format("Unable to roll back %v: %v", root, rerr)
+		}
+		repo.ReloadInstalled(root)
 		wield.Ldconfig(root)
+		return err
 	}
 
-	//Preinstall
-	for _, pkg := range spkgs {
-		wield.PreInstall(pkg.spkg, root)
+	return tx.Commit()
+}
+
+//Rolls back anything left half done by a crash
+func recoverRoot(root string) error {
+	if err := transaction.Recover(root); err != nil {
+		return fmt.Errorf("Unable to recover unfinished transaction: %v", err.Error())
 	}
-	log.Debug.Println()
+	repo.ReloadInstalled(root)
+	return nil
+}
 
-	//PostInstall
-	for _, pkg := range spkgs {
-		wield.PostInstall(pkg.spkg, root)
+func addToWorld(pkgs []spdl.Dep, root string) error {
+	world, err := repo.LoadWorld(root)
+	if err != nil {
+		return err
 	}
-	log.Info.Println()
+	for _, pkg := range pkgs {
+		world.Add(pkg.Name)
+	}
+	return world.Save(root)
+}
 
-	return nil
+//Shows which repo a package will come from
+func prettyNode(n *crunch.Node) string {
+	return n.Repo.Name + spdl.RepoSeparator + n.Pkginfo().PrettyString()
 }
 
 func sortp(orig []*crunch.Node) (nl []*crunch.Node) {
@@ -0,0 +1,100 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const LockFile = "/var/lib/spack/lock"
+
+//Advisory lock on a root, held until Release
+type Lock struct {
+	file      *os.File
+	exclusive bool
+}
+
+//For anything that changes the root
+func Exclusive(root string) (*Lock, error) {
+	return acquire(root, true)
+}
+
+//For read-only queries, any number may be held at once. Never creates the lock
+//file, a root nobody has locked yet can't be changing under us
+func Shared(root string) (*Lock, error) {
+	return acquire(root, false)
+}
+
+func acquire(root string, exclusive bool) (*Lock, error) {
+	path := filepath.Clean(root + LockFile)
+	var file *os.File
+	var err error
+	if exclusive {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	} else {
+		file, err = os.Open(path)
+		if os.IsNotExist(err) {
+			return &Lock{}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("Root %v is locked by %v", root, holder(file))
+		}
+		return nil, err
+	}
+
+	l := &Lock{file: file, exclusive: exclusive}
+	if exclusive {
+		//Let anyone waiting on us know who we are
+		pid := strconv.Itoa(os.Getpid())
+		if err := file.Truncate(0); err != nil {
+			l.Release()
+			return nil, err
+		}
+		if _, err := file.WriteAt([]byte(pid+"\n"), 0); err != nil {
+			l.Release()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func holder(file *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	pid := strings.TrimSpace(string(buf[:n]))
+	if pid == "" {
+		return "a read-only operation"
+	}
+	return "process " + pid
+}
+
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	if l.exclusive {
+		l.file.Truncate(0)
+	}
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,86 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	root, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ex, err := Exclusive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Shared(root)
+	if err == nil {
+		t.Fatalf("Shared lock should fail while an exclusive lock is held")
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("Error should name the holding pid: %v", err)
+	}
+	if err := ex.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	sh1, err := Shared(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh2, err := Shared(root)
+	if err != nil {
+		t.Fatalf("Shared locks should not conflict: %v", err)
+	}
+	_, err = Exclusive(root)
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("Exclusive lock should fail while shared locks are held: %v", err)
+	}
+	sh1.Release()
+	sh2.Release()
+
+	ex, err = Exclusive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Release()
+}
+
+func TestSharedReadOnly(t *testing.T) {
+	root, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	sh, err := Shared(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(root + LockFile); !os.IsNotExist(err) {
+		t.Errorf("A shared lock should not create the lock file")
+	}
+
+	ex, err := Exclusive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Release()
+	if err := os.Chmod(root+LockFile, 0444); err != nil {
+		t.Fatal(err)
+	}
+	sh, err = Shared(root)
+	if err != nil {
+		t.Fatalf("A shared lock only needs to read the lock file: %v", err)
+	}
+	sh.Release()
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -95,6 +96,37 @@ func CopyFile(src, dest string) error {
 	return err
 }
 
+//Writes reader to a temporary file next to file and renames it over file,
+//readers only ever see the old or the new content. An existing file keeps its mode
+func WriteFileAtomic(file string, reader io.Reader) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+
+	_, err = io.Copy(out, reader)
+	if err == nil {
+		err = out.Chmod(mode)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
 func InDir(path string, action func()) error {
 	prevDir, _ := os.Getwd()
 	if err := os.Chdir(path); err != nil {
@@ -192,3 +224,4 @@ func AskYesNo(question string, def bool) bool {
 var GitRegex = regexp.MustCompile(".*\\.git")
 var RsyncRegex = regexp.MustCompile("rsync://.*")
 var HttpRegex = regexp.MustCompile("(http|https|ftp)://.*")
+var FileRegex = regexp.MustCompile("file://.*")
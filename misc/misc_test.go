@@ -0,0 +1,38 @@
+package misc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	if err := WriteFileAtomic(file, strings.NewReader("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(file, strings.NewReader("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(file); string(data) != "two" {
+		t.Errorf("Expected the new content, got %q", data)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The existing mode should be kept, got %v (%v)", info.Mode(), err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("No temporary file should be left behind, got %d files", len(files))
+	}
+}
@@ -12,6 +12,7 @@ type ProgressBar struct {
 	size            int64
 	percentComplete int
 	stdout          bool
+	drawn           bool //Something is on the current line
 }
 
 func NewProgress(out io.Writer, size int64, toStdout bool) io.Writer {
@@ -23,6 +24,23 @@ func NewProgress(out io.Writer, size int64, toStdout bool) io.Writer {
 	return io.MultiWriter(pg, out)
 }
 
+//Bar which is told how far along it is instead of being written to
+func NewBar(toStdout bool) *ProgressBar {
+	return &ProgressBar{stdout: toStdout}
+}
+
+//Total is -1 when unknown, done == total ends the bar's line
+func (prog *ProgressBar) Update(done int64, total int64) {
+	if total >= 0 {
+		prog.size = total
+	}
+	prog.print(done - prog.count)
+	if done == total && prog.drawn {
+		fmt.Println()
+		prog.drawn = false
+	}
+}
+
 func (prog *ProgressBar) Write(p []byte) (n int, err error) {
 	n = len(p)
 	prog.print(int64(n))
@@ -49,6 +67,7 @@ func (prog *ProgressBar) print(n int64) {
 			progStr += strings.Repeat(" ", length-prog.percentComplete)
 			//TODO find a better way to make sure it doesn't go beyond 100%
 			fmt.Printf("\r   [%s] %d/%d %d%%", progStr, prog.count, prog.size, int((float64(prog.percentComplete)/float64(length))*100))
+			prog.drawn = true
 		}
 	} else {
 		prog.percentComplete++
@@ -57,5 +76,6 @@ func (prog *ProgressBar) print(n int64) {
 		progStr += "<==>"
 		progStr += strings.Repeat(" ", length-(len(progStr)))
 		fmt.Printf("\r   [%s] %d/???", progStr, prog.count)
+		prog.drawn = true
 	}
 }
@@ -0,0 +1,222 @@
+package publish
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/repo"
+	"github.com/serenitylinux/libspack/sign"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+type Options struct {
+	Keep    int                //Newest versions kept per package, 0 keeps every build
+	KeyName string             //Name the signing key has in client keyrings
+	Key     ed25519.PrivateKey //Signs the result if set
+}
+
+type Result struct {
+	Added  []string
+	Pruned []string
+}
+
+type published struct {
+	file string
+	spkg *spakg.Spakg
+}
+
+//Adds the spakg files to the http repo in dir and rewrites its index to match.
+//The new list and signed index are written and swapped in before anything they
+//no longer cover is pruned, so readers always see a consistent set
+func Publish(files []string, dir string, opts Options) (*Result, error) {
+	//The stale index would not cover anything published now, and clients which
+	//have seen it signed refuse it unsigned anyway
+	if opts.Key == nil && sign.IsSigned(dir) {
+		return nil, fmt.Errorf("Refusing to publish unsigned into %s, it is signed", dir)
+	}
+
+	pkgs := filepath.Join(dir, repo.PkgsDir)
+	if err := os.MkdirAll(pkgs, 0755); err != nil {
+		return nil, err
+	}
+	res := &Result{make([]string, 0), make([]string, 0)}
+
+	for _, file := range files {
+		s, err := readSpakg(file)
+		if err != nil {
+			return nil, err
+		}
+		dest := filepath.Join(pkgs, s.Pkginfo.String()+".spakg")
+		src, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		err = WriteFileAtomic(dest, src)
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+		log.Debug.Format("Published %s", dest)
+		res.Added = append(res.Added, s.Pkginfo.String())
+	}
+
+	all, err := scan(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	kept, superseded := prune(all, opts.Keep)
+
+	spkgs := make([]*spakg.Spakg, 0, len(kept))
+	for _, p := range kept {
+		spkgs = append(spkgs, p.spkg)
+	}
+	info, err := repo.InfoFiles(spkgs)
+	if err != nil {
+		return nil, err
+	}
+	list, err := repo.StageIndex(info, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed *sign.Signed
+	if opts.Key != nil {
+		idx, err := index(list, kept)
+		if err != nil {
+			return nil, err
+		}
+		if signed, err = idx.Sign(opts.KeyName, opts.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := repo.SwapIndex(list, dir); err != nil {
+		return nil, err
+	}
+	if signed != nil {
+		if err := signed.Write(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := repo.PruneInfo(list, dir); err != nil {
+		return nil, err
+	}
+	for _, p := range superseded {
+		log.Debug.Format("Pruning superseded %s", p.file)
+		if err := os.Remove(p.file); err != nil {
+			log.Warn.Format("Unable to prune %s: %s", p.file, err)
+			continue
+		}
+		res.Pruned = append(res.Pruned, p.spkg.Pkginfo.String())
+	}
+	return res, nil
+}
+
+//Signed index of the info files in list and the kept spakgs
+func index(list *repo.PackageList, kept []published) (*sign.Index, error) {
+	idx := &sign.Index{Date: time.Now(), Files: make(map[string]string, len(list.Files)+len(kept))}
+	for name, sum := range list.Files {
+		idx.Files[name] = sum
+	}
+	for _, p := range kept {
+		sum, err := hash.Sum(sign.IndexAlgorithm, p.file)
+		if err != nil {
+			return nil, err
+		}
+		idx.Files[filepath.Base(p.file)] = sum
+	}
+	return idx, nil
+}
+
+func readSpakg(file string) (*spakg.Spakg, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := spakg.FromReader(f, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", file, err)
+	}
+	return s, nil
+}
+
+func scan(pkgs string) ([]published, error) {
+	files, err := ioutil.ReadDir(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]published, 0, len(files))
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".spakg") {
+			continue
+		}
+		file := filepath.Join(pkgs, f.Name())
+		s, err := readSpakg(file)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, published{file, s})
+	}
+	return all, nil
+}
+
+//Splits off every build older than the newest keep versions of its package
+func prune(all []published, keep int) ([]published, []published) {
+	superseded := make([]published, 0)
+	if keep <= 0 {
+		return all, superseded
+	}
+
+	byName := make(map[string][]control.Control)
+	for _, p := range all {
+		byName[p.spkg.Control.Name] = appendVersion(byName[p.spkg.Control.Name], p.spkg.Control)
+	}
+	for name, versions := range byName {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].GreaterThan(versions[j]) })
+		if len(versions) > keep {
+			versions = versions[:keep]
+		}
+		byName[name] = versions
+	}
+
+	kept := make([]published, 0, len(all))
+	for _, p := range all {
+		if hasVersion(byName[p.spkg.Control.Name], p.spkg.Control) {
+			kept = append(kept, p)
+		} else {
+			superseded = append(superseded, p)
+		}
+	}
+	return kept, superseded
+}
+
+//Builds with other flags share a version
+func appendVersion(versions []control.Control, c control.Control) []control.Control {
+	if hasVersion(versions, c) {
+		return versions
+	}
+	return append(versions, c)
+}
+
+func hasVersion(versions []control.Control, c control.Control) bool {
+	for _, v := range versions {
+		if v.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
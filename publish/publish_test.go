@@ -0,0 +1,154 @@
+package publish
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/repo"
+	"github.com/serenitylinux/libspack/sign"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+func forge(t *testing.T, dir string, name string, version string) (string, *spakg.Spakg) {
+	c := control.Control{Name: name, Version: version, Iteration: 1}
+	s := &spakg.Spakg{Control: c, Pkginfo: *pkginfo.FromControl(&c), Hashes: make(hash.HashList)}
+	file := filepath.Join(dir, s.Pkginfo.String()+".spakg")
+	if err := s.ToFile(file, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	return file, s
+}
+
+func TestPublish(t *testing.T) {
+	out, err := ioutil.TempDir("", "forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	dir, err := ioutil.TempDir("", "published")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, key, err := sign.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Keep: 1, KeyName: "release", Key: key}
+
+	oldFile, old := forge(t, out, "app", "1.0")
+	if _, err := Publish([]string{oldFile}, dir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	newFile, new := forge(t, out, "app", "1.1")
+	libFile, lib := forge(t, out, "lib", "2.0")
+	res, err := Publish([]string{newFile, libFile}, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Pruned, []string{old.Pkginfo.String()}) {
+		t.Errorf("Expected the old app to be pruned, got %v", res.Pruned)
+	}
+	if _, err := os.Stat(filepath.Join(dir, repo.PkgsDir, old.Pkginfo.String()+".spakg")); !os.IsNotExist(err) {
+		t.Errorf("Pruned spakg should be removed")
+	}
+
+	list, err := repo.LoadPackageList(filepath.Join(dir, repo.PackageListName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		new.Control.String() + ".control", new.Pkginfo.String() + ".pkginfo", new.Pkginfo.String() + repo.FilesSuffix,
+		lib.Control.String() + ".control", lib.Pkginfo.String() + ".pkginfo", lib.Pkginfo.String() + repo.FilesSuffix,
+	}
+	if len(list.Files) != len(expected) {
+		t.Errorf("Unexpected list %v", list.Files)
+	}
+	for _, name := range expected {
+		if _, ok := list.Files[name]; !ok {
+			t.Errorf("%v missing from the list", name)
+		}
+	}
+	if list.Bundle == "" {
+		t.Errorf("List should cover the bundle")
+	}
+
+	idx, err := sign.LoadIndex(dir, sign.Keyring{"release": pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range expected {
+		if err := idx.Check(filepath.Join(dir, "info", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := idx.Check(filepath.Join(dir, repo.PkgsDir, new.Pkginfo.String()+".spakg")); err != nil {
+		t.Error(err)
+	}
+	if _, ok := idx.Files[old.Pkginfo.String()+".spakg"]; ok {
+		t.Errorf("Pruned spakg should not be signed")
+	}
+	if len(idx.Files) != len(expected)+2 {
+		t.Errorf("Index should cover the list and the kept spakgs only, got %v", idx.Files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, repo.BundleName+".new")); !os.IsNotExist(err) {
+		t.Errorf("Staged bundle should have been swapped in")
+	}
+}
+
+func TestPublishUnsignedOverSigned(t *testing.T) {
+	out, err := ioutil.TempDir("", "forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	dir, err := ioutil.TempDir("", "published")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, key, err := sign.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFile, old := forge(t, out, "app", "1.0")
+	if _, err := Publish([]string{oldFile}, dir, Options{KeyName: "release", Key: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	newFile, new := forge(t, out, "app", "1.1")
+	if _, err := Publish([]string{newFile}, dir, Options{}); err == nil {
+		t.Errorf("Publishing unsigned into a signed repo should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, repo.PkgsDir, new.Pkginfo.String()+".spakg")); !os.IsNotExist(err) {
+		t.Errorf("Nothing should have been published")
+	}
+
+	//The signed index still covers everything published
+	idx, err := sign.LoadIndex(dir, sign.Keyring{"release": pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.LoadPackageList(filepath.Join(dir, repo.PackageListName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range list.Files {
+		if err := idx.Check(filepath.Join(dir, repo.InfoDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := idx.Check(filepath.Join(dir, repo.PkgsDir, old.Pkginfo.String()+".spakg")); err != nil {
+		t.Error(err)
+	}
+}
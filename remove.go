@@ -0,0 +1,223 @@
+package libspack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cam72cam/go-lumberjack/color"
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/crunch"
+	"github.com/serenitylinux/libspack/lock"
+	"github.com/serenitylinux/libspack/misc"
+	"github.com/serenitylinux/libspack/repo"
+	"github.com/serenitylinux/libspack/spakg"
+	"github.com/serenitylinux/libspack/spdl"
+	"github.com/serenitylinux/libspack/transaction"
+	"github.com/serenitylinux/libspack/wield"
+)
+
+type RemoveOptions struct {
+	Cascade bool //Also remove installed packages which depend on the targets
+}
+
+func Remove(pkgs []spdl.Dep, root string, opts RemoveOptions) error {
+	rootLock, err := lock.Exclusive(root)
+	if err != nil {
+		return err
+	}
+	defer rootLock.Release()
+
+	if err := recoverRoot(root); err != nil {
+		return err
+	}
+
+	installed, err := installedInRoot(root)
+	if err != nil {
+		return fmt.Errorf("Unable to load currently installed packages: %v", err.Error())
+	}
+
+	plan := make([]installedPkg, 0, len(pkgs))
+	inPlan := make(map[string]bool)
+	for _, dep := range pkgs {
+		pkg, ok := installed[dep.Name]
+		if !ok || !acceptsInstalled(dep, pkg.set) {
+			return fmt.Errorf("Package %v is not installed", dep.String())
+		}
+		if !inPlan[dep.Name] {
+			plan = append(plan, pkg)
+			inPlan[dep.Name] = true
+		}
+	}
+
+	//Cascade plan, dependents are appended after what they depend on
+	blocked := make([]string, 0)
+	for i := 0; i < len(plan); i++ {
+		rdeps, err := repo.RdepList(plan[i].set.PkgInfo, root)
+		if err != nil {
+			return err
+		}
+		for _, rdep := range rdeps {
+			if inPlan[rdep.PkgInfo.Name] {
+				continue
+			}
+			if !opts.Cascade {
+				blocked = append(blocked, fmt.Sprintf("%s is required by %s", plan[i].set.PkgInfo.PrettyString(), rdep.PkgInfo.PrettyString()))
+				continue
+			}
+			plan = append(plan, installed[rdep.PkgInfo.Name])
+			inPlan[rdep.PkgInfo.Name] = true
+		}
+	}
+	if len(blocked) != 0 {
+		return fmt.Errorf("Unable to remove packages:\n\t%s", strings.Join(blocked, "\n\t"))
+	}
+
+	if err := checkRemovable(plan, root); err != nil {
+		return err
+	}
+
+	fmt.Println(color.White.String("Packages to Remove:"))
+	for _, pkg := range plan {
+		fmt.Println(pkg.set.PkgInfo.PrettyString())
+	}
+
+	if !misc.AskYesNo("Do you wish to continue?", true) {
+		return nil
+	}
+
+	if err := removePlan(plan, root); err != nil {
+		return err
+	}
+
+	misc.PrintSuccess()
+	return nil
+}
+
+//Runs the remove hooks around uninstalling plan, which is ordered with
+//dependencies before their dependents. Rolled back as a whole if any of it fails
+func removePlan(plan []installedPkg, root string) error {
+	//Dependents go first
+	for i, j := 0, len(plan)-1; i < j; i, j = i+1, j-1 {
+		plan[i], plan[j] = plan[j], plan[i]
+	}
+
+	for i := range plan {
+		loadPkginstall(&plan[i])
+	}
+
+	world, err := repo.LoadWorld(root)
+	if err != nil {
+		return err
+	}
+
+	tx, err := transaction.Begin(root)
+	if err != nil {
+		return err
+	}
+
+	remove := func() (err error) {
+		//Whatever was removed leaves the world with it, journaled so a rollback restores it
+		defer func() {
+			if err != nil {
+				return
+			}
+			if err = tx.Backup(root + repo.WorldFile); err == nil {
+				err = world.Save(root)
+			}
+		}()
+
+		//PreRemove
+		for _, pkg := range plan {
+			if err := wield.PreRemove(&pkg.set, root); err != nil {
+				return err
+			}
+		}
+		log.Debug.Println()
+
+		//Remove
+		for _, pkg := range plan {
+			if err := pkg.repo.Uninstall(pkg.set.PkgInfo, root, tx); err != nil {
+				return err
+			}
+			world.Remove(pkg.set.PkgInfo.Name)
+		}
+		wield.Ldconfig(root)
+		log.Debug.Println()
+
+		//PostRemove
+		for _, pkg := range plan {
+			if err := wield.PostRemove(&pkg.set, root); err != nil {
+				return err
+			}
+		}
+		log.Info.Println()
+		return nil
+	}
+
+	if err := remove(); err != nil {
+		log.Error.Format("Remove failed, rolling back: %v", err)
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Error.Format("Unable to roll back %v: %v", root, rerr)
+		}
+		repo.ReloadInstalled(root)
+		wield.Ldconfig(root)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func acceptsInstalled(dep spdl.Dep, set repo.PkgInstallSet) bool {
+	if dep.Version1 != nil && !dep.Version1.Accepts(set.PkgInfo.Version) {
+		return false
+	}
+	if dep.Version2 != nil && !dep.Version2.Accepts(set.PkgInfo.Version) {
+		return false
+	}
+	return true
+}
+
+//Resolves the installed packages without the plan and makes sure none of it is still needed
+func checkRemovable(plan []installedPkg, root string) error {
+	graph, err := crunch.NewGraph(root, repo.GetAllRepos())
+	if err != nil {
+		return fmt.Errorf("Unable to load package graph: %v", err.Error())
+	}
+	if err := graph.EnableInstalled(); err != nil {
+		return fmt.Errorf("Unable to load currently installed packges: %v", err.Error())
+	}
+	for _, pkg := range plan {
+		if _, ok := graph.Find(pkg.set.PkgInfo.Name); ok {
+			if err := graph.DisableInstalled(pkg.set.PkgInfo.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := graph.Crunch(); err != nil {
+		return err
+	}
+
+	for _, pkg := range plan {
+		if node, ok := graph.Find(pkg.set.PkgInfo.Name); ok && node.IsEnabled() {
+			return fmt.Errorf("%s is still required by:\n%s", pkg.set.PkgInfo.PrettyString(), node.Reason())
+		}
+	}
+	return nil
+}
+
+//Sets installed before hooks were recorded fall back to the cached spakg
+func loadPkginstall(pkg *installedPkg) {
+	if pkg.set.Pkginstall != "" {
+		return
+	}
+	file := pkg.repo.GetSpakgOutput(*pkg.set.PkgInfo)
+	if !misc.PathExists(file) {
+		return
+	}
+	spkg, err := spakg.FromFile(file, nil)
+	if err != nil {
+		log.Warn.Format("Unable to load remove hooks for %v: %v", pkg.set.PkgInfo.PrettyString(), err)
+		return
+	}
+	pkg.set.Pkginstall = spkg.Pkginstall
+}
@@ -2,87 +2,203 @@ package repo
 
 import (
 	"fmt"
-	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/cam72cam/go-lumberjack/log"
 	"github.com/serenitylinux/libspack/control"
 	"github.com/serenitylinux/libspack/hash"
-	"github.com/serenitylinux/libspack/helpers/http"
 	"github.com/serenitylinux/libspack/pkginfo"
 	"github.com/serenitylinux/libspack/spakg"
+	"github.com/serenitylinux/libspack/transaction"
 )
 import . "github.com/serenitylinux/libspack/misc"
 
+//The spakg only lands in SpakgDir once it matches the repo's signed index, if it has one
 func (repo *Repo) FetchIfNotCachedSpakg(p pkginfo.PkgInfo) error {
 	out := repo.GetSpakgOutput(p)
-	if !PathExists(out) {
-		src := repo.RemotePackages + "/pkgs/" + url.QueryEscape(fmt.Sprintf("%s.spakg", p))
-		log.Info.Format("Fetching %s", src)
-		err := http.HttpFetchFileProgress(src, out, true)
-		if err != nil {
-			os.Remove(out)
-		}
+	if PathExists(out) {
+		return nil
+	}
+
+	idx, err := repo.packagesIndex()
+	if err != nil {
 		return err
 	}
-	return nil
+	name := fmt.Sprintf("%s.spakg", p)
+	var sum string
+	if idx != nil {
+		var ok bool
+		sum, ok = idx.Files[name]
+		if !ok {
+			return fmt.Errorf("Refusing %v from %v: not in the signed index", name, repo.Name)
+		}
+	}
+
+	return repo.withMirrors(repo.packageMirrors(), func(remote string) error {
+		log.Info.Format("Fetching %s from %s", name, remote)
+		return fetchFile(remote, remotePath(remote, PkgsDir, name), out, sum, true)
+	})
+}
+
+func (repo *Repo) InstallSpakg(spkg *spakg.Spakg, basedir string, reason InstallReason, tx *transaction.Transaction) error {
+	ps := NewPkgIS(&spkg.Control, &spkg.Pkginfo, spkg.Hashes, reason)
+	ps.Pkginstall = spkg.Pkginstall
+	ps.Links = spkg.Links
+	return repo.installSet(ps, basedir, tx)
 }
 
-func (repo *Repo) InstallSpakg(spkg *spakg.Spakg, basedir string) error {
-	return repo.Install(spkg.Control, spkg.Pkginfo, spkg.Md5sums, basedir)
+//Files and install sets replaced or removed are journaled in tx, which may be nil
+func (repo *Repo) Install(c control.Control, p pkginfo.PkgInfo, hl hash.HashList, basedir string, reason InstallReason, tx *transaction.Transaction) error {
+	return repo.installSet(NewPkgIS(&c, &p, hl, reason), basedir, tx)
 }
 
-func (repo *Repo) Install(c control.Control, p pkginfo.PkgInfo, hl hash.HashList, basedir string) error {
-	ps := NewPkgIS(&c, &p, hl)
+func (repo *Repo) installSet(ps *PkgInstallSet, basedir string, tx *transaction.Transaction) error {
 	err := os.MkdirAll(basedir+repo.installedPkgsDir(), 0755)
 	if err != nil {
 		return err
 	}
 
-	err = repo.MapInstalledByName(c.Name, basedir, func(old PkgInstallSet) {
-		if old.PkgInfo.String() != p.String() {
+	index, err := loadFileIndex(basedir)
+	if err != nil {
+		return err
+	}
+
+	var interr error
+	err = repo.MapInstalledByName(basedir, ps.Control.Name, func(old PkgInstallSet) {
+		//Upgrading a package must never demote it to an orphan
+		ps.Reason = ps.Reason.Strongest(old.Reason)
+		if old.PkgInfo.String() != ps.PkgInfo.String() {
 			for file, _ := range old.Hashes {
-				if _, exists := hl[file]; !exists {
-					err := os.RemoveAll(file)
+				if _, exists := ps.Hashes[file]; !exists {
+					if index.ownedByOther(file, old.PkgInfo.Name) {
+						continue //Taken over by a package which replaces this one
+					}
+					if old.IsModifiedConfig(file, basedir) {
+						continue
+					}
+					if err := tx.Backup(basedir + file); err != nil {
+						interr = err
+						return
+					}
+					err := os.RemoveAll(basedir + file)
 					if err != nil {
 						log.Warn.Format("Unable to remove old file %s: %s", file, err)
 					}
 				}
 			}
+			if err := tx.Backup(repo.installSetFile(*old.PkgInfo, basedir)); err != nil {
+				interr = err
+				return
+			}
 			repo.MarkRemoved(old.PkgInfo, basedir)
+			index.remove(repo.Name, old)
 		}
 	})
+	if err != nil {
+		return err
+	}
+	if interr != nil {
+		return interr
+	}
+
+	if err := tx.Backup(repo.installSetFile(*ps.PkgInfo, basedir)); err != nil {
+		return err
+	}
+	ps.recordPerms(basedir)
+	err = ps.ToFile(repo.installSetFile(*ps.PkgInfo, basedir))
+	repo.reloadInstalled(basedir)
+	if err != nil {
+		return err
+	}
 
-	err = ps.ToFile(repo.installSetFile(p, basedir))
-	repo.loadInstalledPackagesList()
-	return err
+	index.add(repo.Name, *ps)
+	return index.save(basedir, tx)
 }
 
 func (repo *Repo) MarkRemoved(p *pkginfo.PkgInfo, basedir string) error {
 	return os.Remove(repo.installSetFile(*p, basedir))
 }
 
-func (repo *Repo) Uninstall(p *pkginfo.PkgInfo, root string) error {
-	var err error
-	mapErr := repo.MapInstalledByName(p.Name, root, func(inst PkgInstallSet) {
-		if inst.PkgInfo.String() != p.String() {
+//Every file and install set removed is journaled in tx, which may be nil
+func (repo *Repo) Uninstall(p *pkginfo.PkgInfo, root string, tx *transaction.Transaction) error {
+	index, err := loadFileIndex(root)
+	if err != nil {
+		return err
+	}
+
+	mapErr := repo.MapInstalledByName(root, p.Name, func(inst PkgInstallSet) {
+		if inst.PkgInfo.String() != p.String() || err != nil {
 			return
 		}
 
 		log.Info.Format("Removing %s", inst.PkgInfo)
 
-		for f, _ := range inst.Hashes {
+		index.remove(repo.Name, inst)
+
+		files := make([]string, 0, len(inst.Hashes))
+		for _, f := range inst.Paths() {
+			files = append(files, f)
+			if index.ownedByOther(f, inst.PkgInfo.Name) {
+				log.Debug.Println("Keep: " + root + f + ", owned by another package")
+				continue
+			}
+			if inst.IsModifiedConfig(f, root) {
+				log.Info.Println("Keeping modified config " + root + f)
+				continue
+			}
 			log.Debug.Println("Remove: " + root + f)
-			err := os.Remove(root + f)
-			if err != nil {
-				log.Warn.Println(err)
-				//Do we return or keep trying?
+			path := filepath.Join(root, f)
+			if err = tx.Backup(path); err != nil {
+				return
+			}
+			if rerr := os.Remove(path); rerr != nil && !os.IsNotExist(rerr) {
+				log.Warn.Println(rerr)
 			}
 		}
-		err = os.Remove(root + repo.installedPkgsDir() + inst.PkgInfo.String() + ".pkgset")
+		removeEmptyDirs(files, index.dirs(), root)
+
+		setFile := repo.installSetFile(*inst.PkgInfo, root)
+		if err = tx.Backup(setFile); err != nil {
+			return
+		}
+		if rerr := os.Remove(setFile); rerr != nil && !os.IsNotExist(rerr) {
+			err = rerr
+		}
 	})
+	repo.reloadInstalled(root)
 	if err != nil {
+		dropFileIndex(root)
 		return err
 	}
-	return mapErr
+	if mapErr != nil {
+		dropFileIndex(root)
+		return mapErr
+	}
+	return index.save(root, tx)
+}
+
+//Removes the now empty directories holding files, deepest first. Climbing
+//towards root stops at the first directory still holding files owned in index
+func removeEmptyDirs(files []string, owned map[string]bool, root string) {
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		for dir := filepath.Dir(indexPath(file)); dir != "/" && !owned[dir] && !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	//Deepest first so children are gone before their parents are checked
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+
+	for _, dir := range sorted {
+		if os.Remove(filepath.Join(root, dir)) == nil {
+			log.Debug.Println("Removed empty directory " + filepath.Join(root, dir))
+		}
+	}
 }
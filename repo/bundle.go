@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+//Every control and pkginfo of a remote in one compressed tar, so a refresh is two requests
+const BundleName = "packages.tar.gz"
+
+//Control and pkginfo files describing the spakgs, keyed by file name
+func InfoFiles(spakgs []*spakg.Spakg) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, s := range spakgs {
+		control, err := json.Marshal(s.Control)
+		if err != nil {
+			return nil, err
+		}
+		pkginfo, err := json.Marshal(s.Pkginfo)
+		if err != nil {
+			return nil, err
+		}
+		list, err := json.Marshal(spakgFiles(s))
+		if err != nil {
+			return nil, err
+		}
+		files[s.Control.String()+".control"] = control
+		files[s.Pkginfo.String()+".pkginfo"] = pkginfo
+		files[s.Pkginfo.String()+FilesSuffix] = list
+	}
+	return files, nil
+}
+
+//Compressed tar of files, in name order so the same files give the same bundle
+func Bundle(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: time.Unix(0, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//Writes info/, the bundle and packages.list describing every spakg in spakgDir into dir,
+//in the layout http remotes are read with
+func GenerateIndex(spakgDir string, dir string) error {
+	spakgDir = strings.TrimSuffix(spakgDir, "/") + "/"
+	spakgs := make([]*spakg.Spakg, 0)
+	var err error
+	readErr := readAll(spakgDir, regexp.MustCompile(".*\\.spakg$"), func(file string) {
+		if err != nil {
+			return
+		}
+		var s *spakg.Spakg
+		s, err = spakg.FromFile(file, nil)
+		if err != nil {
+			err = fmt.Errorf("Unable to read %s: %s", file, err)
+			return
+		}
+		spakgs = append(spakgs, s)
+	})
+	if readErr != nil {
+		return readErr
+	}
+	if err != nil {
+		return err
+	}
+
+	files, err := InfoFiles(spakgs)
+	if err != nil {
+		return err
+	}
+	return WriteIndex(files, dir)
+}
+
+//Writes the info files, their bundle and the packages.list covering both into dir.
+//The list goes last, until then readers see the previous consistent set
+func WriteIndex(files map[string][]byte, dir string) error {
+	list, err := StageIndex(files, dir)
+	if err != nil {
+		return err
+	}
+	if err := SwapIndex(list, dir); err != nil {
+		return err
+	}
+	return PruneInfo(list, dir)
+}
+
+//Bundle written by StageIndex, only moved in place by SwapIndex
+const stagedBundleName = BundleName + ".new"
+
+//Writes the info files and their bundle into dir, returning the packages.list
+//covering them. Until it is swapped in readers keep the previous set
+func StageIndex(files map[string][]byte, dir string) (*PackageList, error) {
+	info := filepath.Join(dir, InfoDir)
+	if err := os.MkdirAll(info, 0755); err != nil {
+		return nil, err
+	}
+
+	for name, data := range files {
+		if err := WriteFileAtomic(filepath.Join(info, name), bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle, err := Bundle(files)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, stagedBundleName), bytes.NewReader(bundle)); err != nil {
+		return nil, err
+	}
+	return PackageListOf(files, bundle)
+}
+
+//Moves the bundle staged with list in place, then list itself
+func SwapIndex(list *PackageList, dir string) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(dir, stagedBundleName), filepath.Join(dir, BundleName)); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, PackageListName), bytes.NewReader(data))
+}
+
+//Removes the info files list no longer covers
+func PruneInfo(list *PackageList, dir string) error {
+	return readAll(filepath.Join(dir, InfoDir)+"/", infoRegex, func(file string) {
+		if _, ok := list.Files[filepath.Base(file)]; !ok {
+			os.Remove(file)
+		}
+	})
+}
+
+//Unpacks the entries of the remote's bundle which are missing or changed in dir
+func fetchBundle(remote string, dir string, list *PackageList) error {
+	info := dir + InfoDir + "/"
+	changed := false
+	for name, sum := range list.Files {
+		if sum == "" || !PathExists(info+name) || !unchanged(info+name, sum) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	bundle := dir + BundleName
+	if err := fetchFile(remote, BundleName, bundle, list.Bundle, false); err != nil {
+		return err
+	}
+	defer os.Remove(bundle)
+
+	file, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sum, listed := list.Files[hdr.Name]
+		if !listed || strings.Contains(hdr.Name, "/") {
+			return fmt.Errorf("%s is not in %s", hdr.Name, PackageListName)
+		}
+		if PathExists(info+hdr.Name) && unchanged(info+hdr.Name, sum) {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if ok, err := hash.VerifyBytes(data, sum); err != nil || !ok {
+			return fmt.Errorf("%s in %s does not match %s", hdr.Name, BundleName, PackageListName)
+		}
+		if err := WriteFileAtomic(info+hdr.Name, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+}
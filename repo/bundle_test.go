@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+func writeSpakg(t *testing.T, dir string, name string, version string) *spakg.Spakg {
+	c := control.Control{Name: name, Version: version, Iteration: 1}
+	s := &spakg.Spakg{Control: c, Pkginfo: *pkginfo.FromControl(&c), Hashes: make(hash.HashList)}
+	file := filepath.Join(dir, s.Pkginfo.String()+".spakg")
+	if err := s.ToFile(file, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBundle(t *testing.T) {
+	spakgs, err := ioutil.TempDir("", "spakgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spakgs)
+	remote, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	local, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	local += "/"
+
+	app := writeSpakg(t, spakgs, "app", "1.0")
+	writeSpakg(t, spakgs, "lib", "2.0")
+	if err := GenerateIndex(spakgs, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make([]string, 0)
+	files := http.FileServer(http.Dir(remote))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	if err := cloneHttp(server.URL+"/", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range requests {
+		if strings.HasPrefix(path, "/info/") {
+			t.Errorf("Everything should come from the bundle, fetched %v", path)
+		}
+	}
+	for _, name := range []string{app.Control.String() + ".control", app.Pkginfo.String() + ".pkginfo"} {
+		expected, _ := ioutil.ReadFile(filepath.Join(remote, "info", name))
+		if data, _ := ioutil.ReadFile(local + InfoDir + "/" + name); string(data) != string(expected) {
+			t.Errorf("%v should match the published file, got %q", name, data)
+		}
+	}
+
+	//Without a usable bundle the files are fetched one by one
+	os.Remove(filepath.Join(local, InfoDir, app.Pkginfo.String()+".pkginfo"))
+	ioutil.WriteFile(filepath.Join(remote, BundleName), []byte("corrupt"), 0644)
+	if err := cloneHttp(server.URL+"/", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(local + InfoDir + "/" + app.Pkginfo.String() + ".pkginfo"); err != nil {
+		t.Errorf("Pkginfo should have been fetched on its own: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/helpers/json"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+//Published next to each pkginfo so collisions can be found before anything is fetched
+const FilesSuffix = ".files"
+
+//A package about to be installed and the files it brings
+type Incoming struct {
+	Control control.Control
+	Pkginfo pkginfo.PkgInfo
+	Files   []string
+}
+
+func IncomingSpakg(s *spakg.Spakg) Incoming {
+	return Incoming{s.Control, s.Pkginfo, spakgFiles(s)}
+}
+
+//Hashed files and links, as symlinks can collide too
+func spakgFiles(s *spakg.Spakg) []string {
+	files := PkgInstallSet{Hashes: s.Hashes, Links: s.Links}.Paths()
+	sort.Strings(files)
+	return files
+}
+
+//Files p installs, from its cached spakg or the list its remote publishes.
+//Not ok if neither is available yet
+func (repo *Repo) FileList(p pkginfo.PkgInfo) ([]string, bool) {
+	if out := repo.GetSpakgOutput(p); PathExists(out) {
+		s, err := spakg.FromFile(out, nil)
+		if err != nil {
+			log.Warn.Format("Unable to read %v: %v", out, err)
+			return nil, false
+		}
+		return spakgFiles(s), true
+	}
+
+	file := repo.infoDir() + p.String() + FilesSuffix
+	if !PathExists(file) {
+		return nil, false
+	}
+	idx, err := repo.packagesIndex()
+	if err != nil || !repo.trusted(idx, file) {
+		return nil, false
+	}
+	var files []string
+	if err := json.DecodeFile(file, &files); err != nil {
+		log.Warn.Format("Invalid file list %s in repo %s: %v", file, repo.Name, err)
+		return nil, false
+	}
+	return files, true
+}
+
+func replaces(c control.Control, name string) bool {
+	for _, r := range c.Replaces {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func CheckCollisions(spkgs []*spakg.Spakg, root string, force bool) error {
+	pkgs := make([]Incoming, 0, len(spkgs))
+	for _, spkg := range spkgs {
+		pkgs = append(pkgs, IncomingSpakg(spkg))
+	}
+	return CheckIncoming(pkgs, root, force)
+}
+
+//Makes sure none of the packages about to be installed in root overwrite files
+//owned by other packages, or by each other
+func CheckIncoming(pkgs []Incoming, root string, force bool) error {
+	index, err := loadFileIndex(root)
+	if err != nil {
+		return err
+	}
+
+	//Paths each incoming package will own once installed
+	paths := make(map[string]map[string]bool)
+	for _, pkg := range pkgs {
+		owned := make(map[string]bool, len(pkg.Files))
+		for _, file := range pkg.Files {
+			owned[indexPath(file)] = true
+		}
+		paths[pkg.Control.Name] = owned
+	}
+
+	collisions := make([]string, 0)
+	incoming := make(map[string]Incoming)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			path := indexPath(file)
+
+			if other, ok := incoming[path]; ok && other.Control.Name != pkg.Control.Name {
+				if !replaces(pkg.Control, other.Control.Name) && !replaces(other.Control, pkg.Control.Name) {
+					collisions = append(collisions, fmt.Sprintf("%s: %s and %s", path, other.Pkginfo.PrettyString(), pkg.Pkginfo.PrettyString()))
+				}
+			}
+			incoming[path] = pkg
+
+			for _, owner := range index[path] {
+				if owner.Name == pkg.Control.Name || replaces(pkg.Control, owner.Name) {
+					continue
+				}
+				if upgrade, ok := paths[owner.Name]; ok && !upgrade[path] {
+					continue //Owner's new version no longer has it
+				}
+				collisions = append(collisions, fmt.Sprintf("%s: %s::%s (installed) and %s", path, owner.Repo, owner.Package, pkg.Pkginfo.PrettyString()))
+			}
+		}
+	}
+
+	if len(collisions) == 0 {
+		return nil
+	}
+	if force {
+		for _, c := range collisions {
+			log.Warn.Format("Overwriting %s", c)
+		}
+		return nil
+	}
+	return fmt.Errorf("File collisions:\n\t%s", strings.Join(collisions, "\n\t"))
+}
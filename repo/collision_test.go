@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+	"github.com/serenitylinux/libspack/spdl"
+)
+
+func TestCheckCollisions(t *testing.T) {
+	root, err := ioutil.TempDir("", "collision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newSpakg := func(name string, files ...string) *spakg.Spakg {
+		s := &spakg.Spakg{
+			Control: control.Control{Name: name, Version: "1.0"},
+			Pkginfo: pkginfo.PkgInfo{Name: name, Version: "1.0", FlagStates: spdl.NewFlatFlagList(0)},
+			Hashes:  make(hash.HashList),
+		}
+		for _, f := range files {
+			s.Hashes[f] = "sum"
+		}
+		return s
+	}
+
+	r := MockRepo("Test")
+	old := newSpakg("old", "./usr/bin/shared")
+	if err := r.InstallSpakg(old, root, ReasonExplicit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newSpakg("other", "./usr/bin/shared")
+	err = CheckCollisions([]*spakg.Spakg{other}, root, false)
+	if err == nil {
+		t.Fatalf("other should collide with old")
+	}
+	if !strings.Contains(err.Error(), "old") || !strings.Contains(err.Error(), "other") {
+		t.Errorf("Collision should name both packages: %v", err)
+	}
+
+	if err := CheckCollisions([]*spakg.Spakg{other}, root, true); err != nil {
+		t.Errorf("Forced install should not fail: %v", err)
+	}
+
+	other.Control.Replaces = []string{"old"}
+	if err := CheckCollisions([]*spakg.Spakg{other}, root, false); err != nil {
+		t.Errorf("other replaces old: %v", err)
+	}
+
+	moved := newSpakg("old", "./usr/bin/renamed")
+	other.Control.Replaces = nil
+	if err := CheckCollisions([]*spakg.Spakg{other, moved}, root, false); err != nil {
+		t.Errorf("The new version of old no longer has the file: %v", err)
+	}
+
+	twin := newSpakg("twin", "./usr/bin/new")
+	if err := CheckCollisions([]*spakg.Spakg{twin, newSpakg("twin2", "./usr/bin/new")}, root, false); err == nil {
+		t.Errorf("Packages in the same set should not collide either")
+	}
+}
+
+func TestCheckCollisionsLinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "collision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	//Spakg whose fs.tar only holds links, as read back from disk
+	linkSpakg := func(name string) *spakg.Spakg {
+		fs := new(bytes.Buffer)
+		tw := tar.NewWriter(fs)
+		for _, hdr := range []*tar.Header{
+			{Name: "./usr/lib/libfoo.so", Typeflag: tar.TypeSymlink, Linkname: "libfoo.so.1", Mode: 0777},
+			{Name: "./usr/bin/foo-" + name, Typeflag: tar.TypeLink, Linkname: "./usr/bin/foo", Mode: 0755},
+		} {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		c := control.Control{Name: name, Version: "1.0"}
+		s := &spakg.Spakg{Control: c, Pkginfo: *pkginfo.FromControl(&c), Hashes: make(hash.HashList)}
+		file := root + "/" + name + ".spakg"
+		if err := s.ToFile(file, fs); err != nil {
+			t.Fatal(err)
+		}
+		s, err := spakg.FromFile(file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	old := linkSpakg("old")
+	expected := []string{"usr/bin/foo-old", "usr/lib/libfoo.so"}
+	if files := spakgFiles(old); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+
+	r := MockRepo("Test")
+	if err := r.InstallSpakg(old, root, ReasonExplicit, nil); err != nil {
+		t.Fatal(err)
+	}
+	if owners, _ := Owners("/usr/lib/libfoo.so", root); len(owners) != 1 {
+		t.Errorf("The installed symlink should be owned by old, got %v", owners)
+	}
+
+	err = CheckCollisions([]*spakg.Spakg{linkSpakg("other")}, root, false)
+	if err == nil || !strings.Contains(err.Error(), "/usr/lib/libfoo.so") {
+		t.Errorf("Symlinks should collide: %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "foo-other") {
+		t.Errorf("Hard links to different paths should not collide: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/transaction"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+const (
+	PendingConfigsFile = "/var/lib/spack/spacknew" //Config files with a new version waiting to be merged
+	NewConfigSuffix    = ".spacknew"
+)
+
+//Whether file is configuration which was changed since set was installed
+func (set PkgInstallSet) IsModifiedConfig(file string, root string) bool {
+	if !set.Control.IsConfig(file) {
+		return false
+	}
+	path := filepath.Join(root, file)
+	if !PathExists(path) {
+		return false
+	}
+	unchanged, err := hash.Verify(path, set.Hashes[file])
+	if err != nil {
+		return true //Better safe than sorry
+	}
+	return !unchanged
+}
+
+//Config files in root which have a new version waiting, sorted
+func PendingConfigs(root string) ([]string, error) {
+	list := make(map[string]bool)
+	file := root + PendingConfigsFile
+	if !PathExists(file) {
+		return []string{}, nil
+	}
+
+	var interr error
+	err := WithFileReader(file, func(r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			path := strings.TrimSpace(scanner.Text())
+			//Merged by hand
+			if len(path) != 0 && PathExists(root+path+NewConfigSuffix) {
+				list[path] = true
+			}
+		}
+		interr = scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if interr != nil {
+		return nil, interr
+	}
+
+	pending := make([]string, 0, len(list))
+	for path := range list {
+		pending = append(pending, path)
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+func savePendingConfigs(pending []string, root string, tx *transaction.Transaction) error {
+	file := root + PendingConfigsFile
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := tx.Backup(file); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, path := range pending {
+		buf.WriteString(path + "\n")
+	}
+	return WriteFileAtomic(file, &buf)
+}
+
+func AddPendingConfig(path string, root string, tx *transaction.Transaction) error {
+	pending, err := PendingConfigs(root)
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean("/" + path)
+	for _, p := range pending {
+		if p == path {
+			return nil
+		}
+	}
+	return savePendingConfigs(append(pending, path), root, tx)
+}
+
+//Replaces path with its new version if useNew, otherwise drops the new version
+func MergeConfig(path string, root string, useNew bool) error {
+	path = filepath.Clean("/" + path)
+	newPath := root + path + NewConfigSuffix
+	if !PathExists(newPath) {
+		return os.ErrNotExist
+	}
+
+	var err error
+	if useNew {
+		err = os.Rename(newPath, root+path)
+	} else {
+		err = os.Remove(newPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	pending, err := PendingConfigs(root)
+	if err != nil {
+		return err
+	}
+	return savePendingConfigs(pending, root, nil)
+}
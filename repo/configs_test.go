@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+)
+
+func TestConfigs(t *testing.T) {
+	root, err := ioutil.TempDir("", "configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(root+"/etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(root+"/etc/app.conf", []byte("installed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := hash.File(root + "/etc/app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := control.Control{Name: "app"}
+	set := NewPkgIS(&c, &pkginfo.PkgInfo{Name: "app"}, hash.HashList{"./etc/app.conf": sum}, ReasonExplicit)
+	if set.IsModifiedConfig("./etc/app.conf", root) {
+		t.Errorf("app.conf has not been modified yet")
+	}
+
+	if err := ioutil.WriteFile(root+"/etc/app.conf", []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !set.IsModifiedConfig("./etc/app.conf", root) {
+		t.Errorf("app.conf has been modified")
+	}
+
+	if err := ioutil.WriteFile(root+"/etc/app.conf"+NewConfigSuffix, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPendingConfig("./etc/app.conf", root, nil); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := PendingConfigs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pending, []string{"/etc/app.conf"}) {
+		t.Errorf("Unexpected pending configs: %v", pending)
+	}
+
+	if err := MergeConfig("/etc/app.conf", root, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(root + "/etc/app.conf")
+	if string(data) != "new" {
+		t.Errorf("app.conf should have been replaced by the new version, got %q", data)
+	}
+	if pending, _ := PendingConfigs(root); len(pending) != 0 {
+		t.Errorf("Nothing should be pending after the merge: %v", pending)
+	}
+}
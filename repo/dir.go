@@ -1,18 +1,23 @@
 package repo
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/cam72cam/go-lumberjack/log"
 	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
 	"github.com/serenitylinux/libspack/helpers/git"
 	"github.com/serenitylinux/libspack/helpers/http"
+	"github.com/serenitylinux/libspack/helpers/rsync"
 	"github.com/serenitylinux/libspack/helpers/json"
 	"github.com/serenitylinux/libspack/pkginfo"
-	"github.com/serenitylinux/libspack/spakg"
+	"github.com/serenitylinux/libspack/sign"
 )
 
 import . "github.com/serenitylinux/libspack/misc"
@@ -21,19 +26,32 @@ import . "github.com/serenitylinux/libspack/misc"
 Repo Dir Management
 */
 
-func (repo *Repo) RefreshRemote() {
+//Returns what changed in the packages the repo provides
+func (repo *Repo) RefreshRemote() RefreshSummary {
+	before := repo.entries
+
 	if repo.RemoteTemplates != "" {
 		log.Info.Println("Checking remoteTemplates")
-		log.Debug.Println(repo.RemoteTemplates)
-		cloneRepo(repo.RemoteTemplates, repo.templatesDir(), repo.Name)
+		err := repo.withMirrors(repo.templateMirrors(), func(remote string) error {
+			log.Debug.Println(remote)
+			return repo.cloneTemplates(remote)
+		})
+		if err != nil {
+			log.Warn.Println(err)
+		}
 	}
 	if repo.RemotePackages != "" {
 		log.Info.Println("Checking remotePackages")
-		log.Debug.Println(repo.RemotePackages)
-		cloneRepo(repo.RemotePackages, repo.packagesDir(), repo.Name)
+		if err := repo.clonePackages(repo.packagesDir()); err != nil {
+			log.Warn.Println(err)
+		}
 	}
 
 	repo.UpdateCaches()
+
+	summary := diffEntries(before, repo.entries)
+	log.Info.Format("%s: %s", repo.Name, summary)
+	return summary
 }
 
 func (repo *Repo) UpdateCaches() {
@@ -41,13 +59,19 @@ func (repo *Repo) UpdateCaches() {
 	//if we have remote templates
 	if repo.RemoteTemplates != "" {
 		repo.updateControlsFromTemplates()
-		// else if we just have remote controls and prebuilt packages
-	} else if repo.RemotePackages != "" {
-		repo.updateControlsFromRemote()
 	}
 
 	if repo.RemotePackages != "" {
-		repo.updatePkgInfosFromRemote()
+		idx, err := repo.packagesIndex()
+		if err != nil {
+			log.Warn.Format("Refusing remote packages: %v", err)
+		} else {
+			// if we just have remote controls and prebuilt packages
+			if repo.RemoteTemplates == "" {
+				repo.updateControlsFromRemote(idx)
+			}
+			repo.updatePkgInfosFromRemote(idx)
+		}
 	}
 
 	repo.loadLocal()
@@ -60,47 +84,214 @@ func (repo *Repo) LoadCaches() {
 	repo.loadInstalledPackagesList()
 }
 
-func cloneRepo(remote string, dir string, name string) {
+//Files which exist but fail check are fetched again, check may be nil
+func cloneRepo(remote string, dir string, name string, check func(file string) bool) error {
 	switch {
 	case GitRegex.MatchString(remote):
 		os.MkdirAll(dir, 0755)
-		err := git.CloneOrUpdate(remote, dir)
+		_, err := git.CloneOrUpdate(remote, dir, "")
 		if err != nil {
-			log.Warn.Format("Update repository %s %s failed: %s", name, remote, err)
+			return fmt.Errorf("Update repository %s %s failed: %s", name, remote, err)
 		}
 	case HttpRegex.MatchString(remote):
+		return cloneHttp(remote, dir, check)
+	case RsyncRegex.MatchString(remote):
 		os.MkdirAll(dir, 0755)
-		listFile := "packages.list"
-		err := http.HttpFetchFileProgress(remote+listFile, dir+listFile, false)
-		if err != nil {
-			log.Warn.Println(err, remote+listFile)
-			return
+		//Spakgs are fetched one by one when needed
+		if err := rsync.Sync(remote, dir, "/"+PkgsDir+"/"); err != nil {
+			return fmt.Errorf("Sync repository %s %s failed: %s", name, remote, err)
 		}
+	case isLocal(remote):
+		//Read in place, nothing to fetch
+		if path, _ := localPath(remote); !PathExists(path) {
+			return fmt.Errorf("Repository %s %s does not exist", name, remote)
+		}
+	default:
+		return fmt.Errorf("Unknown repository format %s: '%s'", name, remote)
+	}
+	return nil
+}
 
-		list := make([]string, 0)
-		err = json.DecodeFile(dir+listFile, &list)
-		if err != nil {
-			log.Warn.Println(err)
-			return
+//Digests every mirror's files are held to during a refresh
+type packagesPin struct {
+	list *PackageList
+	idx  *sign.Index //nil if the repo is unsigned
+}
+
+func (pin *packagesPin) check(file string) bool {
+	return pin.idx == nil || pin.idx.Check(file) == nil
+}
+
+//The first http or local mirror to serve a trusted index and packages.list pins
+//them, the mirrors after it only fill in files matching the pinned digests. Git
+//and rsync remotes replace the whole copy, index included, which must then be
+//trusted. A local primary is read in place and takes no mirrors
+func (repo *Repo) clonePackages(dir string) error {
+	whole := func(remote string) error {
+		if err := cloneRepo(remote, dir, repo.Name, nil); err != nil {
+			return err
+		}
+		_, err := repo.loadIndex(dir)
+		return err
+	}
+	if isLocal(repo.RemotePackages) {
+		return whole(repo.RemotePackages)
+	}
+
+	var pin *packagesPin
+	return repo.withMirrors(repo.packageMirrors(), func(remote string) error {
+		log.Debug.Println(remote)
+		if !HttpRegex.MatchString(remote) && !isLocal(remote) {
+			return whole(remote)
 		}
 
-		for _, item := range list {
-			if !PathExists(dir + item) {
-				log.Debug.Format("Fetching %s", item)
-				src := remote + "/info/" + url.QueryEscape(item)
-				err = http.HttpFetchFileProgress(src, dir+item, false)
-				if err != nil {
-					log.Warn.Println("Unable to fetch %s: %s", err)
-				}
-			} else {
-				log.Debug.Format("Skipping %s", item)
+		if pin == nil {
+			var err error
+			if pin, err = repo.pinRemote(remote, dir); err != nil {
+				return err
 			}
 		}
+		return fetchInfo(remote, dir, pin.list, pin.check)
+	})
+}
+
+//Fetches the index and packages.list of remote aside, only moving them into dir
+//once the index is trusted
+func (repo *Repo) pinRemote(remote string, dir string) (*packagesPin, error) {
+	os.MkdirAll(dir, 0755)
+	staging, err := ioutil.TempDir(dir, "pin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	staging += "/"
+
+	list, err := fetchList(remote, staging)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := repo.loadIndex(staging)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil && list.Version < 2 {
+		log.Warn.Format("%s has no digests, other mirrors of %s can not be checked against it", remote+PackageListName, repo.Name)
+	}
+
+	for _, file := range []string{sign.IndexName, sign.SignatureName, PackageListName} {
+		os.Remove(dir + file)
+		if !PathExists(staging + file) {
+			continue
+		}
+		if err := os.Rename(staging+file, dir+file); err != nil {
+			return nil, err
+		}
+	}
+	log.Debug.Format("Pinned the index of %s from %s", repo.Name, remote)
+	return &packagesPin{list, idx}, nil
+}
+
+//Where git templates were last checked out from, recorded in the repo cache
+type templatesState struct {
+	Remote string
+	Ref    string
+	Commit string
+}
+
+func (repo *Repo) cloneTemplates(remote string) error {
+	if !GitRegex.MatchString(remote) {
+		if repo.TemplatesRef != "" {
+			log.Warn.Format("Ignoring ref %s of %s, only git remotes can be pinned", repo.TemplatesRef, repo.Name)
+		}
+		return cloneRepo(remote, repo.templatesDir(), repo.Name, nil)
+	}
+
+	dir := repo.templatesDir()
+	os.MkdirAll(dir, 0755)
+	commit, err := git.CloneOrUpdate(remote, dir, repo.TemplatesRef)
+	if err != nil {
+		return fmt.Errorf("Update repository %s %s failed: %s", repo.Name, remote, err)
+	}
+	log.Debug.Format("Templates for %s at %s", repo.Name, commit)
+
+	state := templatesState{Remote: remote, Ref: repo.TemplatesRef, Commit: commit}
+	return json.EncodeFile(repo.templatesStateFile(), state)
+}
+
+//Commit the git templates were last checked out at, empty if unknown
+func (repo *Repo) TemplatesCommit() string {
+	var state templatesState
+	file := repo.templatesStateFile()
+	if !PathExists(file) {
+		return ""
+	}
+	if err := json.DecodeFile(file, &state); err != nil {
+		log.Warn.Format("Could not load template state for repo %s: %s", repo.Name, err)
+		return ""
+	}
+	return state.Commit
+}
+
+func isLocal(remote string) bool {
+	_, ok := localPath(remote)
+	return ok
+}
+
+//Directory a file:// or plain path remote points at, with a trailing slash
+func localPath(remote string) (string, bool) {
+	var path string
+	switch {
+	case GitRegex.MatchString(remote):
+		return "", false
+	case FileRegex.MatchString(remote):
+		path = strings.TrimPrefix(remote, "file://")
+	case filepath.IsAbs(remote):
+		path = remote
+	default:
+		return "", false
+	}
+	return strings.TrimSuffix(path, "/") + "/", true
+}
+
+//Path of name in dir of remote, as fetchFile takes it
+func remotePath(remote string, dir string, name string) string {
+	if HttpRegex.MatchString(remote) {
+		name = url.QueryEscape(name)
+	}
+	return dir + "/" + name
+}
+
+//Fetches file from the root of remote into out, which only appears once it matches sum
+func fetchFile(remote string, file string, out string, sum string, progress bool) error {
+	if HttpRegex.MatchString(remote) {
+		opts := http.DefaultOptions(progress)
+		opts.Hash = sum
+		return http.Fetch(strings.TrimSuffix(remote, "/")+"/"+file, out, opts)
+	}
+
+	tmp := out + http.PartialSuffix
+	var err error
+	switch {
 	case RsyncRegex.MatchString(remote):
-		log.Warn.Println("TODO rsync repo")
+		err = rsync.Fetch(strings.TrimSuffix(remote, "/")+"/"+file, tmp)
+	case isLocal(remote):
+		path, _ := localPath(remote)
+		err = CopyFile(path+file, tmp)
 	default:
-		log.Warn.Format("Unknown repository format %s: '%s'", name, remote)
+		err = fmt.Errorf("Unable to fetch %s from %s", file, remote)
+	}
+	if err == nil && sum != "" {
+		var ok bool
+		ok, err = hash.Verify(tmp, sum)
+		if err == nil && !ok {
+			err = fmt.Errorf("Sum of %s from %s does not match, expected %s", file, remote, sum)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
 	}
+	return os.Rename(tmp, out)
 }
 
 func readAll(dir string, regex *regexp.Regexp, todo func(file string)) error {
@@ -163,25 +354,45 @@ func (repo *Repo) addEntry(e Entry) {
 	repo.entries[key][foundIndex] = found
 }
 
+//Only templates which changed since the last refresh are evaluated again
 func (repo *Repo) updateControlsFromTemplates() {
-	readFunc := func(file string) {
-		c, err := control.FromTemplateFile(file)
-		if err != nil {
-			log.Warn.Format("Invalid template in repo %s (%s) : %s", repo.Name, file, err.Error())
-			return
+	var files []string
+	err := readAll(repo.templatesDir(), templateRegex, func(file string) {
+		files = append(files, file)
+	})
+	if err != nil {
+		log.Warn.Format("Unable to load repo %s's templates: %s", repo.Name, err)
+	}
+
+	cache := evalTemplates(repo.Name, repo.templatesDir(), files, repo.loadTemplateCache())
+	for _, file := range files {
+		if e, ok := cache[file]; ok {
+			repo.addEntry(Entry{Control: e.Control, Template: file})
 		}
-		repo.addEntry(Entry{Control: c, Template: file})
 	}
 
-	err := readAll(repo.templatesDir(), regexp.MustCompile(".*\\.pie$"), readFunc)
-	if err != nil {
-		log.Warn.Format("Unable to load repo %s's templates: %s", repo.Name, err)
+	if err := json.EncodeFile(repo.templateCacheFile(), cache); err != nil {
+		log.Warn.Format("Unable to save repo %s's template cache: %s", repo.Name, err)
+	}
+}
+
+func (repo *Repo) loadTemplateCache() templateCache {
+	cache := make(templateCache)
+	cf := repo.templateCacheFile()
+	if PathExists(cf) {
+		if err := json.DecodeFile(cf, &cache); err != nil {
+			log.Warn.Format("Could not load template cache for repo %s: %s", repo.Name, err)
+		}
 	}
+	return cache
 }
 
 //TODO merge with updatePkgiInfosFromRemote
-func (repo *Repo) updateControlsFromRemote() {
+func (repo *Repo) updateControlsFromRemote(idx *sign.Index) {
 	readFunc := func(file string) {
+		if !repo.trusted(idx, file) {
+			return
+		}
 		var c control.Control
 		err := json.DecodeFile(file, &c)
 		if err != nil {
@@ -191,14 +402,17 @@ func (repo *Repo) updateControlsFromRemote() {
 		repo.addEntry(Entry{Control: c})
 	}
 
-	err := readAll(repo.packagesDir(), regexp.MustCompile(".*.control"), readFunc)
+	err := readAll(repo.infoDir(), regexp.MustCompile(".*.control"), readFunc)
 	if err != nil {
 		log.Warn.Format("Unable to load repo %s's controls: %s", repo.Name, err)
 	}
 }
 
-func (repo *Repo) updatePkgInfosFromRemote() {
+func (repo *Repo) updatePkgInfosFromRemote(idx *sign.Index) {
 	readFunc := func(file string) {
+		if !repo.trusted(idx, file) {
+			return
+		}
 		var pki pkginfo.PkgInfo
 		err := json.DecodeFile(file, &pki)
 		if err != nil {
@@ -216,7 +430,7 @@ func (repo *Repo) updatePkgInfosFromRemote() {
 		})
 	}
 
-	err := readAll(repo.packagesDir(), regexp.MustCompile(".*.pkginfo"), readFunc)
+	err := readAll(repo.infoDir(), regexp.MustCompile(".*.pkginfo"), readFunc)
 	if err != nil {
 		log.Warn.Format("Unable to load repo %s's controls: %s", repo.Name, err)
 	}
@@ -224,31 +438,42 @@ func (repo *Repo) updatePkgInfosFromRemote() {
 
 //TODO rewrite
 func (repo *Repo) loadLocal() {
-	readFunc := func(file string) {
-		tmpDir, _ := ioutil.TempDir(os.TempDir(), "repo")
-		defer os.RemoveAll(tmpDir)
+	var files []string
+	err := readAll(repo.spakgDir(), regexp.MustCompile(".*.spakg"), func(file string) {
+		files = append(files, file)
+	})
+	if err != nil {
+		log.Warn.Format("Unable to load repo %s's controls: %s", repo.Name, err)
+	}
 
-		pkg, err := spakg.FromFile(file, &tmpDir)
-		if err != nil {
-			log.Warn.Format("Error loading %v: %v", file, err.Error())
-			return
+	old := make(spakgIndex)
+	if PathExists(repo.spakgIndexFile()) {
+		if err := json.DecodeFile(repo.spakgIndexFile(), &old); err != nil {
+			log.Warn.Format("Could not load spakg index for repo %s: %s", repo.Name, err)
 		}
-		if file != repo.GetSpakgOutput(pkg.Pkginfo) {
-			log.Warn.Format("Error loading %v: %v", file, "Mismatched checksums: "+pkg.Pkginfo.String())
-			return
+	}
+	index, changed := indexSpakgs(files, old)
+	if changed {
+		if err := json.EncodeFile(repo.spakgIndexFile(), index); err != nil {
+			log.Warn.Format("Unable to save repo %s's spakg index: %s", repo.Name, err)
+		}
+	}
+
+	for _, file := range files {
+		e, ok := index[file]
+		if !ok {
+			continue
+		}
+		if file != repo.GetSpakgOutput(e.Pkginfo) {
+			log.Warn.Format("Error loading %v: %v", file, "Mismatched checksums: "+e.Pkginfo.String())
+			continue
 		}
 
 		repo.addEntry(Entry{
-			Control:   pkg.Control,
-			Available: []pkginfo.PkgInfo{pkg.Pkginfo},
+			Control:   e.Control,
+			Available: []pkginfo.PkgInfo{e.Pkginfo},
 		})
 	}
-
-	err := readAll(repo.spakgDir(), regexp.MustCompile(".*.spakg"), readFunc)
-	if err != nil {
-		log.Warn.Format("Unable to load repo %s's controls: %s", repo.Name, err)
-	}
-	return
 }
 
 func (repo *Repo) loadCache() {
@@ -295,6 +520,6 @@ func installedPackageList(dir string) (*PkgInstallSetMap, error) {
 		list[ps.PkgInfo.String()] = *ps
 	}
 
-	err := readAll(dir, regexp.MustCompile(".*.pkgset"), readFunc)
+	err := readAll(dir, regexp.MustCompile(".*\\.pkgset$"), readFunc)
 	return &list, err
 }
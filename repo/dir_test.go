@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/helpers/rsync"
+)
+
+func TestLocalPath(t *testing.T) {
+	cases := map[string]string{
+		"file:///srv/repo":   "/srv/repo/",
+		"/mnt/nfs/repo/":     "/mnt/nfs/repo/",
+		"http://x.org/repo":  "",
+		"rsync://x.org/r":    "",
+		"/srv/templates.git": "",
+	}
+	for remote, expected := range cases {
+		path, ok := localPath(remote)
+		if ok != (expected != "") || path != expected {
+			t.Errorf("%v: expected %q, got %q", remote, expected, path)
+		}
+	}
+
+	r := Repo{Name: "lab", RemotePackages: "file:///srv/lab"}
+	if r.packagesDir() != "/srv/lab/" {
+		t.Errorf("Local packages should be read in place, got %v", r.packagesDir())
+	}
+}
+
+func TestFetchLocal(t *testing.T) {
+	remote, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	out, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	os.MkdirAll(filepath.Join(remote, "pkgs"), 0755)
+	src := filepath.Join(remote, "pkgs", "app-1.0_1.spakg")
+	ioutil.WriteFile(src, []byte("spakg"), 0644)
+	sum, err := hash.File(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(out, "app.spakg")
+	if err := fetchFile("file://"+remote, "pkgs/app-1.0_1.spakg", dest, sum, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(dest); string(data) != "spakg" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	os.Remove(dest)
+	ioutil.WriteFile(src, []byte("tampered"), 0644)
+	if err := fetchFile(remote, "pkgs/app-1.0_1.spakg", dest, sum, false); err == nil {
+		t.Errorf("Mismatched spakg should be refused")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		t.Errorf("Mismatched spakg should not be left in place")
+	}
+}
+
+//Local and rsync remotes are read with the layout publishing writes and http remotes serve
+func TestRefreshPublished(t *testing.T) {
+	remote, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	pkgs := filepath.Join(remote, PkgsDir)
+	os.MkdirAll(pkgs, 0755)
+	app := writeSpakg(t, pkgs, "app", "1.0")
+	if err := GenerateIndex(pkgs, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, remote string) {
+		r := Repo{Name: "test", RemotePackages: remote, Trust: TrustNone}
+		if err := cloneRepo(remote, r.packagesDir(), r.Name, nil); err != nil {
+			t.Fatal(err)
+		}
+		r.entries = make(map[string][]Entry)
+		r.updateControlsFromRemote(nil)
+		r.updatePkgInfosFromRemote(nil)
+		entries := r.entries["app"]
+		if len(entries) != 1 || len(entries[0].Available) != 1 || entries[0].Available[0].String() != app.Pkginfo.String() {
+			t.Errorf("Expected app to be available, got %+v", r.entries)
+		}
+		files, ok := r.FileList(app.Pkginfo)
+		if !ok || strings.Join(files, " ") != strings.Join(spakgFiles(app), " ") {
+			t.Errorf("Expected the published file list %v, got %v", spakgFiles(app), files)
+		}
+	}
+	t.Run("file", func(t *testing.T) {
+		check(t, "file://"+remote)
+	})
+
+	t.Run("rsync", func(t *testing.T) {
+		if _, err := exec.LookPath("rsync"); err != nil {
+			t.Skip("rsync is not installed")
+		}
+		//What cloneRepo does for rsync:// remotes, into the copy packagesDir reads
+		synced, err := ioutil.TempDir("", "synced")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(synced)
+		if err := rsync.Sync(remote, synced, "/"+PkgsDir+"/"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(synced, PkgsDir)); !os.IsNotExist(err) {
+			t.Errorf("Spakgs should not be synced")
+		}
+		check(t, synced)
+	})
+}
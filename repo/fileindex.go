@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/helpers/json"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/transaction"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+const FileIndexFile = "/var/lib/spack/files.json" //Owners of every installed file
+
+type FileOwner struct {
+	Repo    string
+	Name    string
+	Package string //PkgInfo.String() of the owner
+}
+
+//Map<path, owners>, paths are absolute within the root
+type FileIndex map[string][]FileOwner
+
+var fileIndexes = make(map[string]FileIndex)
+
+func indexPath(file string) string {
+	return filepath.Clean("/" + file)
+}
+
+func (index FileIndex) add(repo string, set PkgInstallSet) {
+	owner := FileOwner{repo, set.PkgInfo.Name, set.PkgInfo.String()}
+	for _, file := range set.Paths() {
+		path := indexPath(file)
+		index.removeOwner(path, owner)
+		index[path] = append(index[path], owner)
+	}
+}
+
+func (index FileIndex) remove(repo string, set PkgInstallSet) {
+	owner := FileOwner{repo, set.PkgInfo.Name, set.PkgInfo.String()}
+	for _, file := range set.Paths() {
+		index.removeOwner(indexPath(file), owner)
+	}
+}
+
+func (index FileIndex) removeOwner(path string, owner FileOwner) {
+	owners := index[path]
+	for i, curr := range owners {
+		if curr == owner {
+			owners = append(owners[:i], owners[i+1:]...)
+			break
+		}
+	}
+	if len(owners) == 0 {
+		delete(index, path)
+	} else {
+		index[path] = owners
+	}
+}
+
+//Loads the index of root, rebuilding it from the install sets if it does not exist
+func loadFileIndex(root string) (FileIndex, error) {
+	key := filepath.Clean(root)
+	if index, ok := fileIndexes[key]; ok {
+		return index, nil
+	}
+
+	index := make(FileIndex)
+	file := root + FileIndexFile
+	if PathExists(file) {
+		if err := json.DecodeFile(file, &index); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Debug.Format("Building file index for %v", root)
+		for _, repo := range repos {
+			err := repo.MapInstalled(root, func(set PkgInstallSet) {
+				index.add(repo.Name, set)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fileIndexes[key] = index
+	return index, nil
+}
+
+func (index FileIndex) save(root string, tx *transaction.Transaction) error {
+	file := root + FileIndexFile
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := tx.Backup(file); err != nil {
+		return err
+	}
+	return json.EncodeFile(file, index)
+}
+
+//Every directory holding a file in index
+func (index FileIndex) dirs() map[string]bool {
+	dirs := make(map[string]bool)
+	for path := range index {
+		for dir := filepath.Dir(path); dir != "/" && !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	return dirs
+}
+
+func dropFileIndex(root string) {
+	delete(fileIndexes, filepath.Clean(root))
+}
+
+func (index FileIndex) ownedByOther(path string, name string) bool {
+	for _, owner := range index[indexPath(path)] {
+		if owner.Name != name {
+			return true
+		}
+	}
+	return false
+}
+
+//Whether a package other than name owns path in root
+func IsOwnedByOther(path string, name string, root string) (bool, error) {
+	index, err := loadFileIndex(root)
+	if err != nil {
+		return false, err
+	}
+	return index.ownedByOther(path, name), nil
+}
+
+//Packages which own path in root
+func Owners(path string, root string) ([]FileOwner, error) {
+	index, err := loadFileIndex(root)
+	if err != nil {
+		return nil, err
+	}
+	return index[indexPath(path)], nil
+}
+
+//Files installed by p in root, sorted
+func (repo *Repo) Files(p *pkginfo.PkgInfo, root string) ([]string, error) {
+	files := make([]string, 0)
+	err := repo.MapInstalledByName(root, p.Name, func(set PkgInstallSet) {
+		if set.PkgInfo.String() != p.String() {
+			return
+		}
+		for _, file := range set.Paths() {
+			files = append(files, indexPath(file))
+		}
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+//Files under dir in root which no installed package owns, sorted
+func UnownedFiles(dir string, root string) ([]string, error) {
+	index, err := loadFileIndex(root)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Clean(root)
+	unowned := make([]string, 0)
+	err = filepath.Walk(filepath.Join(base, dir), func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if _, owned := index[indexPath(rel)]; !owned {
+			unowned = append(unowned, indexPath(rel))
+		}
+		return nil
+	})
+	return unowned, err
+}
@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spdl"
+	"github.com/serenitylinux/libspack/transaction"
+)
+
+func TestFileIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(root+"/usr/bin", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"/usr/bin/tool", "/usr/bin/stray"} {
+		if err := ioutil.WriteFile(root+f, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := MockRepo("Test")
+	c := control.Control{Name: "tool", Version: "1.0"}
+	p := pkginfo.PkgInfo{Name: "tool", Version: "1.0", FlagStates: spdl.NewFlatFlagList(0)}
+	err = r.Install(c, p, hash.HashList{"./usr/bin/tool": "sum"}, root, ReasonExplicit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := Owners("/usr/bin/tool", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 1 || owners[0] != (FileOwner{"Test", "tool", p.String()}) {
+		t.Errorf("Unexpected owners of /usr/bin/tool: %v", owners)
+	}
+
+	files, err := r.Files(&p, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"/usr/bin/tool"}) {
+		t.Errorf("Unexpected files of tool: %v", files)
+	}
+
+	unowned, err := UnownedFiles("/usr", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unowned, []string{"/usr/bin/stray"}) {
+		t.Errorf("Unexpected unowned files: %v", unowned)
+	}
+
+	if err := r.Uninstall(&p, root, nil); err != nil {
+		t.Fatal(err)
+	}
+	owners, err = Owners("/usr/bin/tool", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 0 {
+		t.Errorf("/usr/bin/tool should not be owned after uninstall: %v", owners)
+	}
+
+	//Reloaded from disk
+	dropFileIndex(root)
+	if owners, _ := Owners("/usr/bin/tool", root); len(owners) != 0 {
+		t.Errorf("Saved index still owns /usr/bin/tool: %v", owners)
+	}
+}
+
+func TestUninstallDirsAndRollback(t *testing.T) {
+	root, err := ioutil.TempDir("", "uninstall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	r := MockRepo("Test")
+	install := func(name string, files ...string) pkginfo.PkgInfo {
+		hl := make(hash.HashList)
+		for _, f := range files {
+			if err := os.MkdirAll(filepath.Dir(root+f), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(root+f, []byte(f), 0644); err != nil {
+				t.Fatal(err)
+			}
+			hl["."+f] = "sum"
+		}
+		c := control.Control{Name: name, Version: "1.0"}
+		p := pkginfo.PkgInfo{Name: name, Version: "1.0", FlagStates: spdl.NewFlatFlagList(0)}
+		if err := r.Install(c, p, hl, root, ReasonExplicit, nil); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tool := install("tool", "/usr/share/tool/data", "/opt/tool/bin/x")
+	install("other", "/usr/share/other/x")
+
+	tx, err := transaction.Begin(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Uninstall(&tool, root, tx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/usr/share/tool", "/opt"} {
+		if _, err := os.Stat(root + dir); !os.IsNotExist(err) {
+			t.Errorf("%s only held tool's files and should be removed", dir)
+		}
+	}
+	if _, err := os.Stat(root + "/usr/share"); err != nil {
+		t.Errorf("/usr/share holds other's files and should be kept: %v", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadInstalled(root)
+	dropFileIndex(root)
+	for _, f := range []string{"/usr/share/tool/data", "/opt/tool/bin/x"} {
+		if data, _ := ioutil.ReadFile(root + f); string(data) != f {
+			t.Errorf("%s should be restored by the rollback, got %q", f, data)
+		}
+	}
+	if owners, _ := Owners("/usr/share/tool/data", root); len(owners) != 1 {
+		t.Errorf("The rollback should restore the file index, got %v", owners)
+	}
+	if r.GetInstalledByName("tool", root) == nil {
+		t.Errorf("The rollback should restore the install set")
+	}
+}
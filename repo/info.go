@@ -14,15 +14,41 @@ const (
 Package Directories
 */
 func (repo *Repo) templatesDir() string {
+	if dir, ok := localPath(repo.RemoteTemplates); ok {
+		return dir
+	}
 	return TemplatesDir + repo.Name + "/"
 }
 func (repo *Repo) packagesDir() string {
+	if dir, ok := localPath(repo.RemotePackages); ok {
+		return dir
+	}
 	return PackagesDir + repo.Name + "/"
 }
+//Laid out like the remote, whichever way it is fetched
+func (repo *Repo) infoDir() string {
+	return repo.packagesDir() + InfoDir + "/"
+}
 func (repo *Repo) cacheFile() string {
 	os.MkdirAll(ReposCacheDir+repo.Name, 0755) //I am tired and this should work for now
 	return ReposCacheDir + repo.Name + ".json"
 }
+func (repo *Repo) templatesStateFile() string {
+	os.MkdirAll(ReposCacheDir+repo.Name, 0755)
+	return ReposCacheDir + repo.Name + "/templates.json"
+}
+func (repo *Repo) templateCacheFile() string {
+	os.MkdirAll(ReposCacheDir+repo.Name, 0755)
+	return ReposCacheDir + repo.Name + "/controls.json"
+}
+func (repo *Repo) spakgIndexFile() string {
+	os.MkdirAll(ReposCacheDir+repo.Name, 0755)
+	return ReposCacheDir + repo.Name + "/spakgs.json"
+}
+func (repo *Repo) trustStateFile() string {
+	os.MkdirAll(ReposCacheDir+repo.Name, 0755)
+	return ReposCacheDir + repo.Name + "/trust.json"
+}
 func (repo *Repo) installedPkgsDir() string {
 	return InstallDir + repo.Name + "/"
 }
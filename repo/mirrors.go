@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	libhttp "github.com/serenitylinux/libspack/helpers/http"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+//Which mirror of a remote is tried first
+type MirrorOrder string
+
+const (
+	OrderListed  MirrorOrder = "listed"  //As written in the repo config
+	OrderLatency MirrorOrder = "latency" //Fastest to answer first, measured once per session
+)
+
+const latencyTimeout = 5 * time.Second
+
+//Mirrors which failed during this session are not tried again
+var mirrorState = struct {
+	sync.Mutex
+	bad     map[string]bool
+	latency map[string]time.Duration
+}{bad: make(map[string]bool), latency: make(map[string]time.Duration)}
+
+func markMirrorBad(mirror string) {
+	mirrorState.Lock()
+	defer mirrorState.Unlock()
+	mirrorState.bad[mirror] = true
+}
+
+func isMirrorBad(mirror string) bool {
+	mirrorState.Lock()
+	defer mirrorState.Unlock()
+	return mirrorState.bad[mirror]
+}
+
+//Round trip of a HEAD request, unreachable and non http mirrors sort last
+func mirrorLatency(mirror string) time.Duration {
+	mirrorState.Lock()
+	latency, ok := mirrorState.latency[mirror]
+	mirrorState.Unlock()
+	if ok {
+		return latency
+	}
+
+	latency = latencyTimeout
+	if HttpRegex.MatchString(mirror) {
+		client := http.Client{Timeout: latencyTimeout}
+		start := time.Now()
+		resp, err := client.Head(mirror)
+		if err == nil {
+			resp.Body.Close()
+			latency = time.Since(start)
+		}
+	}
+	log.Debug.Format("Mirror %s answered in %v", mirror, latency)
+
+	mirrorState.Lock()
+	mirrorState.latency[mirror] = latency
+	mirrorState.Unlock()
+	return latency
+}
+
+func (repo *Repo) orderMirrors(primary string, mirrors []string) []string {
+	all := make([]string, 0, len(mirrors)+1)
+	for _, mirror := range append([]string{primary}, mirrors...) {
+		if mirror != "" && !isMirrorBad(mirror) {
+			all = append(all, mirror)
+		}
+	}
+	if repo.MirrorOrder == OrderLatency {
+		sort.SliceStable(all, func(i, j int) bool {
+			return mirrorLatency(all[i]) < mirrorLatency(all[j])
+		})
+	}
+	return all
+}
+
+func (repo *Repo) templateMirrors() []string {
+	return repo.orderMirrors(repo.RemoteTemplates, repo.TemplateMirrors)
+}
+
+func (repo *Repo) packageMirrors() []string {
+	return repo.orderMirrors(repo.RemotePackages, repo.PackageMirrors)
+}
+
+//Runs fn against each mirror until one succeeds, the ones that fail are marked bad
+func (repo *Repo) withMirrors(mirrors []string, fn func(mirror string) error) error {
+	if len(mirrors) == 0 {
+		return fmt.Errorf("No working mirrors left for %s", repo.Name)
+	}
+	var err error
+	for _, mirror := range mirrors {
+		err = fn(mirror)
+		if err == nil {
+			return nil
+		}
+		log.Warn.Format("Mirror %s of %s failed: %s", mirror, repo.Name, err)
+		if !libhttp.IsNotFound(err) {
+			markMirrorBad(mirror)
+		}
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMirrors(t *testing.T) {
+	r := &Repo{
+		Name:           "mirrored",
+		RemotePackages: "http://dead.example/",
+		PackageMirrors: []string{"http://alive.example/", "http://spare.example/"},
+	}
+
+	tried := make([]string, 0)
+	err := r.withMirrors(r.packageMirrors(), func(mirror string) error {
+		tried = append(tried, mirror)
+		if mirror == "http://dead.example/" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tried, []string{"http://dead.example/", "http://alive.example/"}) {
+		t.Errorf("Unexpected mirrors tried: %v", tried)
+	}
+
+	//The dead one is skipped for the rest of the session
+	if mirrors := r.packageMirrors(); !reflect.DeepEqual(mirrors, []string{"http://alive.example/", "http://spare.example/"}) {
+		t.Errorf("Unexpected mirrors: %v", mirrors)
+	}
+
+	err = r.withMirrors([]string{}, func(string) error { return nil })
+	if err == nil {
+		t.Errorf("Running out of mirrors should fail")
+	}
+}
+
+func TestMirrorLatency(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	r := &Repo{
+		Name:            "latency",
+		RemoteTemplates: slow.URL + "/",
+		TemplateMirrors: []string{"git://example.com/templates.git", fast.URL + "/"},
+		MirrorOrder:     OrderLatency,
+	}
+	expected := []string{fast.URL + "/", slow.URL + "/", "git://example.com/templates.git"}
+	if mirrors := r.templateMirrors(); !reflect.DeepEqual(mirrors, expected) {
+		t.Errorf("Expected %v, got %v", expected, mirrors)
+	}
+}
+
+func TestClonePackagesPinned(t *testing.T) {
+	serve := func(files map[string]string, info bool) *httptest.Server {
+		remote, err := ioutil.TempDir("", "remote")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(remote) })
+		publish(t, remote, files)
+		fs := http.FileServer(http.Dir(remote))
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !info && strings.HasPrefix(r.URL.Path, "/"+InfoDir+"/") {
+				http.NotFound(w, r)
+				return
+			}
+			fs.ServeHTTP(w, r)
+		}))
+	}
+	current := map[string]string{"app-1.0.control": "app", "lib-1.0.pkginfo": "lib"}
+
+	//Lists the current packages but has none of the files
+	partial := serve(current, false)
+	defer partial.Close()
+	//Has everything, from another publish
+	stale := serve(map[string]string{"app-1.0.control": "app stale", "lib-1.0.pkginfo": "lib stale"}, true)
+	defer stale.Close()
+	full := serve(current, true)
+	defer full.Close()
+
+	local, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	local += "/"
+
+	r := &Repo{
+		Name:           "pinned",
+		RemotePackages: partial.URL + "/",
+		PackageMirrors: []string{stale.URL + "/", full.URL + "/"},
+		Trust:          TrustNone,
+	}
+	if err := r.clonePackages(local); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range current {
+		if data, _ := ioutil.ReadFile(local + InfoDir + "/" + name); string(data) != content {
+			t.Errorf("Expected %s from the pinned list, got %q", name, data)
+		}
+	}
+	if !isMirrorBad(stale.URL + "/") {
+		t.Errorf("A mirror serving other files than the pinned list should be marked bad")
+	}
+}
+
+func TestClonePackagesLocalMirror(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	mirror, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mirror)
+	files := map[string]string{"app-1.0.control": "app", "lib-1.0.pkginfo": "lib"}
+	publish(t, mirror, files)
+
+	for _, remote := range []string{mirror, "file://" + mirror} {
+		local, err := ioutil.TempDir("", "local")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(local)
+		local += "/"
+
+		r := &Repo{
+			Name:           "local-mirror",
+			RemotePackages: down.URL + "/",
+			PackageMirrors: []string{remote},
+			Trust:          TrustNone,
+		}
+		if err := r.clonePackages(local); err != nil {
+			t.Fatalf("%s: %s", remote, err)
+		}
+		for name, content := range files {
+			if data, _ := ioutil.ReadFile(local + InfoDir + "/" + name); string(data) != content {
+				t.Errorf("Expected %s from %s, got %q", name, remote, data)
+			}
+		}
+		if _, err := os.Stat(local + PackageListName); err != nil {
+			t.Errorf("Expected %s from %s", PackageListName, remote)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/sign"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+const (
+	PackageListName    = "packages.list"
+	PackageListVersion = 2
+	PkgsDir            = "pkgs" //Where remotes keep their spakgs
+	InfoDir            = "info" //Where remotes, and local copies of them, keep controls and pkginfos
+)
+
+var infoRegex = regexp.MustCompile(".*\\.(control|pkginfo|files)$")
+
+//Every info file an http remote publishes under /info/, with its digest.
+//Version 1 lists are a bare array of names and carry no digests
+type PackageList struct {
+	Version int
+	Files   map[string]string
+	Bundle  string `json:",omitempty"` //Hash of BundleName, empty if the remote only has the files
+}
+
+func LoadPackageList(file string) (*PackageList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var list PackageList
+	if err := json.Unmarshal(data, &list); err == nil {
+		if list.Files == nil {
+			list.Files = make(map[string]string)
+		}
+		return &list, nil
+	}
+
+	names := make([]string, 0)
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", file, err)
+	}
+	list = PackageList{1, make(map[string]string), ""}
+	for _, name := range names {
+		list.Files[name] = ""
+	}
+	return &list, nil
+}
+
+//Lists and hashes every control and pkginfo in dir
+func NewPackageList(dir string) (*PackageList, error) {
+	list := &PackageList{PackageListVersion, make(map[string]string), ""}
+	var err error
+	readErr := readAll(dir, infoRegex, func(file string) {
+		sum, e := hash.File(file)
+		if e != nil {
+			err = e
+			return
+		}
+		list.Files[strings.TrimPrefix(file, dir)] = sum
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	return list, err
+}
+
+//Lists info files, named as in info/, and the bundle made of them
+func PackageListOf(files map[string][]byte, bundle []byte) (*PackageList, error) {
+	list := &PackageList{PackageListVersion, make(map[string]string, len(files)), ""}
+	for name, data := range files {
+		sum, err := hash.SumBytes(hash.Default, data)
+		if err != nil {
+			return nil, err
+		}
+		list.Files[name] = sum
+	}
+	var err error
+	list.Bundle, err = hash.SumBytes(hash.Default, bundle)
+	return list, err
+}
+
+//Fetches what changed since the last refresh and removes what is gone.
+//Files which exist but fail check are fetched again, check may be nil
+func cloneHttp(remote string, dir string, check func(file string) bool) error {
+	list, err := fetchList(remote, dir)
+	if err != nil {
+		return err
+	}
+	return fetchInfo(remote, dir, list, check)
+}
+
+//Fetches the index, its signature and packages.list of an http or local remote into dir
+func fetchList(remote string, dir string) (*PackageList, error) {
+	os.MkdirAll(dir, 0755)
+	for _, file := range []string{sign.IndexName, sign.SignatureName} {
+		os.Remove(dir + file)
+		if err := fetchFile(remote, file, dir+file, "", false); err != nil {
+			log.Debug.Format("No %s in %s: %s", file, remote, err)
+		}
+	}
+
+	if err := fetchFile(remote, PackageListName, dir+PackageListName, "", false); err != nil {
+		return nil, fmt.Errorf("%s %s", err, remote+PackageListName)
+	}
+	return LoadPackageList(dir + PackageListName)
+}
+
+//Brings info/ of dir in line with list, fetching from remote whatever is missing
+//or does not match list's digests. Files which exist but fail check are fetched
+//again, check may be nil
+func fetchInfo(remote string, dir string, list *PackageList, check func(file string) bool) error {
+	info := dir + InfoDir + "/"
+	os.MkdirAll(info, 0755)
+	if list.Bundle != "" {
+		if err := fetchBundle(remote, dir, list); err != nil {
+			log.Warn.Format("Unable to use %s, fetching files one by one: %s", remote+BundleName, err)
+		}
+	}
+
+	//Left from before info files were kept under info/
+	err := readAll(dir, infoRegex, func(file string) {
+		os.Remove(file)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = readAll(info, infoRegex, func(file string) {
+		if _, ok := list.Files[strings.TrimPrefix(file, info)]; !ok {
+			log.Debug.Format("Removing %s, gone from %s", file, remote)
+			os.Remove(file)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	//Keep going so the next mirror only has to provide what this one could not
+	var failed error
+	for item, sum := range list.Files {
+		if !infoRegex.MatchString(item) || strings.Contains(item, "/") {
+			log.Warn.Format("Ignoring %s in %s", item, remote+PackageListName)
+			continue
+		}
+
+		local := info + item
+		if PathExists(local) && (sum == "" || unchanged(local, sum)) && (check == nil || check(local)) {
+			log.Debug.Format("Skipping %s", item)
+			continue
+		}
+
+		log.Debug.Format("Fetching %s", item)
+		err = fetchFile(remote, remotePath(remote, InfoDir, item), local, sum, false)
+		if err == nil && check != nil && !check(local) {
+			os.Remove(local)
+			err = fmt.Errorf("%s from %s does not match the signed index", item, remote)
+		}
+		if err != nil {
+			log.Warn.Format("Unable to fetch %s: %s", item, err)
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	return failed
+}
+
+func unchanged(file string, sum string) bool {
+	ok, err := hash.Verify(file, sum)
+	return err == nil && ok
+}
+
+//Packages a refresh added, changed or dropped
+type RefreshSummary struct {
+	Added   []string
+	Updated []string
+	Removed []string
+}
+
+func (s RefreshSummary) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed", len(s.Added), len(s.Updated), len(s.Removed))
+}
+
+func diffEntries(before, after map[string][]Entry) RefreshSummary {
+	summary := RefreshSummary{make([]string, 0), make([]string, 0), make([]string, 0)}
+	for name, entries := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			summary.Added = append(summary.Added, name)
+		case !sameEntries(old, entries):
+			summary.Updated = append(summary.Updated, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			summary.Removed = append(summary.Removed, name)
+		}
+	}
+	sort.Strings(summary.Added)
+	sort.Strings(summary.Updated)
+	sort.Strings(summary.Removed)
+	return summary
+}
+
+//Compared as json, entries loaded from the cache file went through it
+func sameEntries(a, b []Entry) bool {
+	aj, aerr := json.Marshal(a)
+	bj, berr := json.Marshal(b)
+	return aerr == nil && berr == nil && string(aj) == string(bj)
+}
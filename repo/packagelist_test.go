@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/serenitylinux/libspack/helpers/json"
+)
+
+func publish(t *testing.T, remote string, files map[string]string) {
+	info := filepath.Join(remote, "info")
+	os.RemoveAll(info)
+	os.MkdirAll(info, 0755)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(info, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := NewPackageList(info + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.EncodeFile(filepath.Join(remote, PackageListName), list); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloneHttp(t *testing.T) {
+	remote, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	local, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	local += "/"
+
+	requests := make(map[string]int)
+	files := http.FileServer(http.Dir(remote))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[filepath.Base(r.URL.Path)]++
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	publish(t, remote, map[string]string{
+		"app-1.0.control": "app",
+		"lib-1.0.pkginfo": "lib",
+	})
+	if err := cloneHttp(server.URL+"/", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(local + InfoDir + "/app-1.0.control"); string(data) != "app" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	//Republished lib, dropped app
+	publish(t, remote, map[string]string{
+		"lib-1.0.pkginfo": "lib rebuilt",
+		"new-1.0.pkginfo": "new",
+	})
+	requests = make(map[string]int)
+	if err := cloneHttp(server.URL+"/", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(local + InfoDir + "/lib-1.0.pkginfo"); string(data) != "lib rebuilt" {
+		t.Errorf("Republished pkginfo should be fetched again, got %q", data)
+	}
+	if _, err := os.Stat(local + InfoDir + "/app-1.0.control"); !os.IsNotExist(err) {
+		t.Errorf("Entries gone from the remote should be removed")
+	}
+
+	//Nothing changed
+	requests = make(map[string]int)
+	if err := cloneHttp(server.URL+"/", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	if requests["lib-1.0.pkginfo"] != 0 || requests["new-1.0.pkginfo"] != 0 {
+		t.Errorf("Unchanged entries should not be fetched again: %v", requests)
+	}
+}
+
+func TestLoadPackageListLegacy(t *testing.T) {
+	file, err := ioutil.TempFile("", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`["app-1.0.control", "app-1.0_1.pkginfo"]`)
+	file.Close()
+
+	list, err := LoadPackageList(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"app-1.0.control": "", "app-1.0_1.pkginfo": ""}
+	if list.Version != 1 || !reflect.DeepEqual(list.Files, expected) {
+		t.Errorf("Unexpected list %+v", list)
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	before := map[string][]Entry{
+		"kept":    {{Template: "kept.pie"}},
+		"changed": {{Template: "changed.pie"}},
+		"gone":    {{Template: "gone.pie"}},
+	}
+	after := map[string][]Entry{
+		"kept":    {{Template: "kept.pie"}},
+		"changed": {{Template: "changed-2.pie"}},
+		"new":     {{Template: "new.pie"}},
+	}
+	summary := diffEntries(before, after)
+	expected := RefreshSummary{[]string{"new"}, []string{"changed"}, []string{"gone"}}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, summary)
+	}
+}
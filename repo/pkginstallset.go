@@ -1,21 +1,127 @@
 package repo
 
 import (
+	"os"
+	"path/filepath"
+	"time"
+
 	"github.com/serenitylinux/libspack/control"
 	"github.com/serenitylinux/libspack/hash"
 	"github.com/serenitylinux/libspack/helpers/json"
+	"github.com/serenitylinux/libspack/misc"
 	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spdl"
+)
+
+type InstallReason int
+
+//Zero value is explicit so sets written before reasons were recorded are never orphaned
+const (
+	ReasonExplicit        = InstallReason(0)
+	ReasonDependency      = InstallReason(1)
+	ReasonBuildDependency = InstallReason(2)
 )
 
+func (r InstallReason) String() string {
+	switch r {
+	case ReasonExplicit:
+		return "explicit"
+	case ReasonDependency:
+		return "dependency"
+	case ReasonBuildDependency:
+		return "build dependency"
+	default:
+		return "unknown"
+	}
+}
+
+//The reason which keeps a package installed the longest
+func (r InstallReason) Strongest(other InstallReason) InstallReason {
+	if other < r {
+		return other
+	}
+	return r
+}
+
 type PkgInstallSet struct {
-	Control *control.Control
-	PkgInfo *pkginfo.PkgInfo
-	Hashes  hash.HashList
+	Control     *control.Control
+	PkgInfo     *pkginfo.PkgInfo
+	Hashes      hash.HashList
+	Reason      InstallReason
+	InstallDate time.Time
+	Pkginstall  string //Install and remove hooks from the spakg
+	Perms       map[string]FilePerm
+	Links       []string //Symlinks and hard links, which have no hash
+}
+
+type FilePerm struct {
+	Mode os.FileMode
+	Uid  int
+	Gid  int
+}
+
+func NewPkgIS(c *control.Control, p *pkginfo.PkgInfo, hash hash.HashList, reason InstallReason) *PkgInstallSet {
+	return &PkgInstallSet{c, p, hash, reason, time.Now(), "", nil, nil}
 }
 
-func NewPkgIS(c *control.Control, p *pkginfo.PkgInfo, hash hash.HashList) *PkgInstallSet {
-	return &PkgInstallSet{c, p, hash}
+//Deps enabled by the flags this package was built with
+func (p PkgInstallSet) EnabledDeps() spdl.DepList {
+	deps := make(spdl.DepList, 0, len(p.Control.Deps))
+	for _, dep := range p.Control.Deps {
+		if dep.Condition != nil && !dep.Condition.Enabled(p.PkgInfo.FlagStates) {
+			continue
+		}
+		deps = append(deps, dep)
+	}
+	return deps
 }
+
+//Whether this package needs other, as built with its installed flags
+func (p PkgInstallSet) DependsOn(other *pkginfo.PkgInfo) bool {
+	for _, dep := range p.EnabledDeps() {
+		if dep.Name != other.Name {
+			continue
+		}
+		if dep.Version1 != nil && !dep.Version1.Accepts(other.Version) {
+			continue
+		}
+		if dep.Version2 != nil && !dep.Version2.Accepts(other.Version) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+//Every path installed, hashed files and links
+func (p PkgInstallSet) Paths() []string {
+	paths := make([]string, 0, len(p.Hashes)+len(p.Links))
+	seen := make(map[string]bool, len(p.Hashes))
+	for file := range p.Hashes {
+		paths = append(paths, file)
+		seen[indexPath(file)] = true
+	}
+	for _, link := range p.Links {
+		if !seen[indexPath(link)] {
+			paths = append(paths, link)
+		}
+	}
+	return paths
+}
+
+//Records the permissions the files were installed with in root
+func (p *PkgInstallSet) recordPerms(root string) {
+	p.Perms = make(map[string]FilePerm, len(p.Hashes))
+	for file := range p.Hashes {
+		fi, err := os.Lstat(filepath.Join(root, file))
+		if err != nil {
+			continue
+		}
+		uid, gid := misc.GetUidGid(fi)
+		p.Perms[file] = FilePerm{fi.Mode(), uid, gid}
+	}
+}
+
 func (p *PkgInstallSet) ToFile(filename string) error {
 	return json.EncodeFile(filename, p)
 }
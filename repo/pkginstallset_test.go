@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spdl"
+)
+
+func TestPkgInstallSetDependsOn(t *testing.T) {
+	var c control.Control
+	err := json.Unmarshal([]byte(`
+{
+	"Name": "app",
+	"Version": "1.0.0",
+	"Iteration": 1,
+	"Deps": [
+		"[+qt]qtbase>=5.0.0",
+		"libc"
+	],
+	"Flags": [
+		"-qt"
+	]
+}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qtbase := func(version string) *pkginfo.PkgInfo {
+		return &pkginfo.PkgInfo{Name: "qtbase", Version: version, FlagStates: spdl.NewFlatFlagList(0)}
+	}
+	libc := &pkginfo.PkgInfo{Name: "libc", Version: "2.0", FlagStates: spdl.NewFlatFlagList(0)}
+
+	withoutQt := NewPkgIS(&c, pkginfo.FromControl(&c), hash.HashList{}, ReasonExplicit)
+	if withoutQt.DependsOn(qtbase("5.1.0")) {
+		t.Errorf("app built -qt should not depend on qtbase")
+	}
+	if !withoutQt.DependsOn(libc) {
+		t.Errorf("app should always depend on libc")
+	}
+
+	p := pkginfo.FromControl(&c)
+	if err := p.SetFlagState(spdl.FlatFlag{Name: "qt", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	withQt := NewPkgIS(&c, p, hash.HashList{}, ReasonExplicit)
+	if !withQt.DependsOn(qtbase("5.1.0")) {
+		t.Errorf("app built +qt should depend on qtbase 5.1.0")
+	}
+	if withQt.DependsOn(qtbase("4.8.0")) {
+		t.Errorf("app built +qt should not depend on qtbase 4.8.0")
+	}
+}
+
+func TestUninstallListAcrossRepos(t *testing.T) {
+	root, err := ioutil.TempDir("", "rdeps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	install := func(r *Repo, name string, deps ...string) *pkginfo.PkgInfo {
+		c := control.Control{Name: name, Version: "1.0"}
+		for _, d := range deps {
+			dep, err := spdl.ParseDep(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Deps = append(c.Deps, dep)
+		}
+		p := pkginfo.PkgInfo{Name: name, Version: "1.0", FlagStates: spdl.NewFlatFlagList(0)}
+		if err := r.Install(c, p, hash.HashList{}, root, ReasonExplicit, nil); err != nil {
+			t.Fatal(err)
+		}
+		return &p
+	}
+
+	//libc -> app (other repo) -> plugin (first repo)
+	core := MockRepo("core")
+	extra := MockRepo("extra")
+	libc := install(core, "libc")
+	install(extra, "app", "libc")
+	install(core, "plugin", "app")
+	install(core, "unrelated")
+
+	rdeps, err := RepoList{"core": core, "extra": extra}.UninstallList(libc, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, set := range rdeps {
+		names = append(names, set.PkgInfo.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"app", "plugin"}) {
+		t.Errorf("Expected app and plugin to break without libc, got %v", names)
+	}
+}
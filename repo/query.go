@@ -110,63 +110,62 @@ func (repo *Repo) GetInstalledByName(name string, destdir string) *PkgInstallSet
 	return nil
 }
 
-// TODO actually check if that dep is enabled or not in the pkginfo
-func (repo *Repo) RdepList(p *pkginfo.PkgInfo) []PkgInstallSet {
+//Installed packages which need p as they were built
+func (repo *Repo) RdepList(p *pkginfo.PkgInfo, root string) ([]PkgInstallSet, error) {
 	pkgs := make([]PkgInstallSet, 0)
 
-	for _, set := range *repo.installed {
-		for _, dep := range set.Control.Deps {
-			if dep.Name == p.Name {
-				pkgs = append(pkgs, set)
-			}
+	list, err := repo.pkgsInstalledInRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, set := range *list {
+		if set.DependsOn(p) {
+			pkgs = append(pkgs, set)
 		}
 	}
 
-	return pkgs
+	return pkgs, nil
 }
 
-// TODO actually check if that dep is enabled or not in the pkginfo
-func (repo *Repo) UninstallList(p *pkginfo.PkgInfo) []PkgInstallSet {
-	pkgs := make([]PkgInstallSet, 0)
-
-	var inner func(*pkginfo.PkgInfo)
-
-	inner = func(cur *pkginfo.PkgInfo) {
-		for _, pkg := range pkgs {
-			if pkg.Control.Name == cur.Name {
-				return
-			}
-		}
+//Installed packages of this repo which would break, directly or not, without p
+func (repo *Repo) UninstallList(p *pkginfo.PkgInfo, root string) ([]PkgInstallSet, error) {
+	return RepoList{repo.Name: repo}.UninstallList(p, root)
+}
 
-		for _, set := range *repo.installed {
-			for _, dep := range set.Control.Deps {
-				if dep.Name == cur.Name {
-					pkgs = append(pkgs, set)
-					inner(set.PkgInfo)
-				}
-			}
+func containsSet(sets []PkgInstallSet, set PkgInstallSet) bool {
+	for _, s := range sets {
+		if s.PkgInfo.String() == set.PkgInfo.String() {
+			return true
 		}
 	}
-
-	inner(p)
-
-	return pkgs
+	return false
 }
 
+//Keyed by repo name and root
 var cachedInstalledRoots = make(map[string]*PkgInstallSetMap)
 
 func (repo *Repo) pkgsInstalledInRoot(destdir string) (*PkgInstallSetMap, error) {
 	if filepath.Clean(destdir) == "/" {
 		return repo.installed, nil
 	} else {
-		if list, ok := cachedInstalledRoots[destdir]; ok {
+		key := repo.Name + "::" + destdir
+		if list, ok := cachedInstalledRoots[key]; ok {
 			return list, nil
 		}
 		list, err := installedPackageList(destdir + InstallDir + repo.Name + "/")
 		if err != nil {
 			return nil, err
 		}
-		cachedInstalledRoots[destdir] = list
+		cachedInstalledRoots[key] = list
 		return list, nil
 	}
 }
+
+func (repo *Repo) reloadInstalled(destdir string) {
+	if filepath.Clean(destdir) == "/" {
+		repo.loadInstalledPackagesList()
+	} else {
+		delete(cachedInstalledRoots, repo.Name+"::"+destdir)
+	}
+}
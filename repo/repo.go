@@ -20,13 +20,23 @@ type Repo struct {
 	Description string
 	//Buildable
 	RemoteTemplates string //Templates
+	TemplatesRef    string //Branch, tag or commit of git RemoteTemplates, the remote's HEAD if empty
 	//Installable (pkgset + spakg)
 	RemotePackages string //Control + PkgInfo
 	Version        string
+	Priority       int         //Preferred over lower priority repos providing the same package
+	Trust          TrustPolicy //Defaults to TrustOptional
+	Keys           []string    //Keyring keys trusted to sign this repo, any if empty
+
+	//Tried after the remote above fails. Local remotes are read in place, so they take no mirrors
+	TemplateMirrors []string
+	PackageMirrors  []string
+	MirrorOrder     MirrorOrder //Defaults to OrderListed
 
 	//Private NOT SERIALIZED
-	entries   map[string][]Entry
-	installed *PkgInstallSetMap
+	entries        map[string][]Entry
+	installed      *PkgInstallSetMap
+	warnedUnsigned bool
 }
 
 func Load(filename string) (*Repo, error) {
@@ -3,12 +3,15 @@ package repo
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 
 	"github.com/cam72cam/go-lumberjack/color"
 	"github.com/cam72cam/go-lumberjack/log"
 	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/lock"
 	"github.com/serenitylinux/libspack/misc"
 	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spdl"
 )
 
 const reposDir = "/etc/spack/repos/"
@@ -40,7 +43,13 @@ func LoadRepos() error {
 	return nil
 }
 
-func RefreshRepos(notRemote bool) {
+func RefreshRepos(notRemote bool) error {
+	rootLock, err := lock.Exclusive("/")
+	if err != nil {
+		return err
+	}
+	defer rootLock.Release()
+
 	log.Info.Println()
 	for _, repo := range repos {
 		log.Info.Println("Refreshing ", repo.Name)
@@ -52,23 +61,100 @@ func RefreshRepos(notRemote bool) {
 		}
 		misc.PrintSuccess()
 	}
+	return nil
+}
+
+//Drops what is known about the packages installed in root, after it was changed behind our back
+func ReloadInstalled(root string) {
+	for _, repo := range repos {
+		repo.reloadInstalled(root)
+	}
+	dropFileIndex(root)
 }
 
 func GetAllRepos() RepoList {
 	return repos
 }
 
+//Highest priority first, ties broken by name so the order never depends on map iteration
+func (list RepoList) Sorted() []*Repo {
+	sorted := make([]*Repo, 0, len(list))
+	for _, repo := range list {
+		sorted = append(sorted, repo)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+//Installed packages which would break, directly or not, without p. Each one
+//found is followed through every repo, as reverse deps can cross repos
+func (list RepoList) UninstallList(p *pkginfo.PkgInfo, root string) ([]PkgInstallSet, error) {
+	pkgs := make([]PkgInstallSet, 0)
+
+	var inner func(*pkginfo.PkgInfo) error
+	inner = func(cur *pkginfo.PkgInfo) error {
+		for _, repo := range list.Sorted() {
+			rdeps, err := repo.RdepList(cur, root)
+			if err != nil {
+				return err
+			}
+			for _, set := range rdeps {
+				if containsSet(pkgs, set) {
+					continue
+				}
+				pkgs = append(pkgs, set)
+				if err := inner(set.PkgInfo); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := inner(p); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+//Whether the repo has any version of the package
+func (repo *Repo) Provides(pkgname string) bool {
+	_, ok := repo.entries[pkgname]
+	return ok
+}
+
+//The highest priority repo providing pkgname
 func GetRepoFor(pkgname string) (*Repo, error) {
-	for _, repo := range repos {
-		if _, ok := repo.entries[pkgname]; ok {
+	for _, repo := range repos.Sorted() {
+		if repo.Provides(pkgname) {
 			return repo, nil
 		}
 	}
 	return nil, fmt.Errorf("Unable to find repo for %v", pkgname)
 }
 
+//The repo dep is pinned to, or the highest priority one providing it
+func GetRepoForDep(dep spdl.Dep) (*Repo, error) {
+	if dep.Repo == "" {
+		return GetRepoFor(dep.Name)
+	}
+	repo, ok := repos[dep.Repo]
+	if !ok {
+		return nil, fmt.Errorf("Unknown repo %v", dep.Repo)
+	}
+	if !repo.Provides(dep.Name) {
+		return nil, fmt.Errorf("Package %v is not available from %v", dep.Name, dep.Repo)
+	}
+	return repo, nil
+}
+
 func GetPackageAllVersions(pkgname string) (res []control.Control, repo *Repo) {
-	for _, repo = range repos {
+	for _, repo = range repos.Sorted() {
 		repo.MapByName(pkgname, func(e Entry) {
 			res = append(res, e.Control)
 		})
@@ -80,7 +166,7 @@ func GetPackageAllVersions(pkgname string) (res []control.Control, repo *Repo) {
 }
 
 func GetPackageVersionIteration(pkgname, version string, iteration int) (c *control.Control, repo *Repo) {
-	for _, repo = range repos {
+	for _, repo = range repos.Sorted() {
 		repo.MapByName(pkgname, func(e Entry) {
 			if e.Control.Version == version && e.Control.Iteration == iteration {
 				c = &e.Control
@@ -93,7 +179,7 @@ func GetPackageVersionIteration(pkgname, version string, iteration int) (c *cont
 	return nil, nil
 }
 func GetPackageVersion(pkgname, version string) (c *control.Control, repo *Repo) {
-	for _, repo = range repos {
+	for _, repo = range repos.Sorted() {
 		repo.MapByName(pkgname, func(e Entry) {
 			if e.Control.Version == version {
 				if c == nil || e.Control.GreaterThan(*c) {
@@ -108,7 +194,7 @@ func GetPackageVersion(pkgname, version string) (c *control.Control, repo *Repo)
 	return nil, nil
 }
 func GetPackageLatest(pkgname string) (c *control.Control, repo *Repo) {
-	for _, repo = range repos {
+	for _, repo = range repos.Sorted() {
 		repo.MapByName(pkgname, func(e Entry) {
 			if c == nil || e.Control.GreaterThan(*c) {
 				c = &e.Control
@@ -121,8 +207,8 @@ func GetPackageLatest(pkgname string) (c *control.Control, repo *Repo) {
 	return nil, nil
 }
 func GetPackageInstalledByName(pkgname string, destdir string) (p *PkgInstallSet, repo *Repo) {
-	for _, repo = range repos {
-		repo.MapInstalledByName(pkgname, destdir, func(installed PkgInstallSet) {
+	for _, repo = range repos.Sorted() {
+		repo.MapInstalledByName(destdir, pkgname, func(installed PkgInstallSet) {
 			p = &installed
 		})
 		if p != nil {
@@ -131,17 +217,17 @@ func GetPackageInstalledByName(pkgname string, destdir string) (p *PkgInstallSet
 	}
 	return nil, nil
 }
-func UninstallList(p *pkginfo.PkgInfo) []PkgInstallSet {
-	res := make([]PkgInstallSet, 0)
-	for _, repo := range repos {
-		res = append(res, repo.UninstallList(p)...)
-	}
-	return res
+func UninstallList(p *pkginfo.PkgInfo, root string) ([]PkgInstallSet, error) {
+	return repos.UninstallList(p, root)
 }
-func RdepList(p *pkginfo.PkgInfo) []PkgInstallSet {
+func RdepList(p *pkginfo.PkgInfo, root string) ([]PkgInstallSet, error) {
 	res := make([]PkgInstallSet, 0)
 	for _, repo := range repos {
-		res = append(res, repo.RdepList(p)...)
+		list, err := repo.RdepList(p, root)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
 	}
-	return res
+	return res, nil
 }
@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"os"
+	"time"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+//Metadata of cached spakgs, keyed by file
+type spakgIndex map[string]spakgIndexEntry
+
+//A spakg with the same size and mtime is assumed unchanged and not reopened
+type spakgIndexEntry struct {
+	Size    int64
+	ModTime time.Time
+	Control control.Control
+	Pkginfo pkginfo.PkgInfo
+}
+
+//Index of files, reusing entries from old which are still current. Files
+//which can not be read are left out. Returns whether anything changed
+func indexSpakgs(files []string, old spakgIndex) (spakgIndex, bool) {
+	index := make(spakgIndex, len(files))
+	changed := false
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Warn.Format("Error loading %v: %v", file, err.Error())
+			continue
+		}
+
+		e, ok := old[file]
+		if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+			meta, err := spakg.MetadataFromFile(file)
+			if err != nil {
+				log.Warn.Format("Error loading %v: %v", file, err.Error())
+				continue
+			}
+			e = spakgIndexEntry{Size: info.Size(), ModTime: info.ModTime(), Control: meta.Control, Pkginfo: meta.Pkginfo}
+			changed = true
+		}
+		index[file] = e
+	}
+	return index, changed || len(index) != len(old)
+}
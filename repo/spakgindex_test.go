@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexSpakgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spakgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := writeSpakg(t, dir, "app", "1.0")
+	file := filepath.Join(dir, s.Pkginfo.String()+".spakg")
+	files := []string{file, filepath.Join(dir, "missing.spakg")}
+
+	index, changed := indexSpakgs(files, spakgIndex{})
+	if !changed || len(index) != 1 {
+		t.Fatalf("Expected only the existing spakg indexed, got %+v", index)
+	}
+	if e := index[file]; e.Control.Name != "app" || e.Pkginfo.String() != s.Pkginfo.String() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	//Same size and mtime is trusted without reopening the file
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := make([]byte, info.Size())
+	if err := ioutil.WriteFile(file, garbage, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	index, changed = indexSpakgs(files, index)
+	if changed || index[file].Control.Name != "app" {
+		t.Errorf("Unchanged spakg should come from the index")
+	}
+
+	//A new mtime means the file is read again
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	index, changed = indexSpakgs(files, index)
+	if !changed || len(index) != 0 {
+		t.Errorf("Modified spakg should have been reread, got %+v", index)
+	}
+}
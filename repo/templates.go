@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"regexp"
+	"runtime"
+	"sync"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+var templateRegex = regexp.MustCompile(".*\\.pie$")
+
+//Each template evaluation is a bash process
+var templateWorkers = runtime.NumCPU()
+
+//Controls evaluated from templates, keyed by template file
+type templateCache map[string]templateCacheEntry
+
+type templateCacheEntry struct {
+	Version     int    //control.TemplateVersion the control was extracted with
+	Hash        string //Of the template
+	DefaultHash string //Of the default file every template in the directory sources, empty if none
+	Control     control.Control
+}
+
+//Evaluates the templates in dir whose content or default file changed since
+//old was built, in parallel. Invalid templates are left out of the result
+func evalTemplates(name string, dir string, files []string, old templateCache) templateCache {
+	var defaultHash string
+	if PathExists(dir + "default") {
+		var err error
+		if defaultHash, err = hash.File(dir + "default"); err != nil {
+			log.Warn.Format("Unable to read repo %s's template defaults: %s", name, err)
+		}
+	}
+
+	results := make([]*templateCacheEntry, len(files))
+	evaluated := make([]bool, len(files))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < templateWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], evaluated[i] = evalTemplate(name, files[i], defaultHash, old)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	cache := make(templateCache, len(files))
+	count := 0
+	for i, e := range results {
+		if evaluated[i] {
+			count++
+		}
+		if e != nil {
+			cache[files[i]] = *e
+		}
+	}
+	log.Debug.Format("Evaluated %d of %d templates in repo %s", count, len(files), name)
+	return cache
+}
+
+//Returns the cached control if the template is unchanged, otherwise evaluates it
+func evalTemplate(name string, file string, defaultHash string, old templateCache) (*templateCacheEntry, bool) {
+	sum, err := hash.File(file)
+	if err != nil {
+		log.Warn.Format("Unable to read template in repo %s (%s) : %s", name, file, err)
+		return nil, false
+	}
+	if e, ok := old[file]; ok && e.Version == control.TemplateVersion && e.Hash == sum && e.DefaultHash == defaultHash {
+		return &e, false
+	}
+
+	c, err := control.FromTemplateFile(file)
+	if err != nil {
+		//The error names the template and field
+		log.Warn.Format("Skipping template in repo %s: %s", name, err.Error())
+		return nil, true
+	}
+	return &templateCacheEntry{Version: control.TemplateVersion, Hash: sum, DefaultHash: defaultHash, Control: c}, true
+}
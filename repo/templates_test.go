@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEvalTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir += "/"
+
+	//Every evaluation leaves a line in the log
+	evalLog := dir + "evaluated.log"
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(dir+file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template := func(name, version string) string {
+		return "name=" + name + "\nversion=" + version + "\niteration=1\necho " + name + " >> " + evalLog + "\n"
+	}
+	evaluated := func() []string {
+		data, _ := ioutil.ReadFile(evalLog)
+		os.Remove(evalLog)
+		return strings.Fields(string(data))
+	}
+
+	write("a.pie", template("a", "1.0"))
+	write("b.pie", template("b", "1.0"))
+	write("broken.pie", "exit 1\n")
+	files := []string{dir + "a.pie", dir + "b.pie", dir + "broken.pie"}
+
+	cache := evalTemplates("test", dir, files, templateCache{})
+	if len(evaluated()) != 2 {
+		t.Errorf("Both valid templates should have been evaluated")
+	}
+	if len(cache) != 2 || cache[dir+"a.pie"].Control.Name != "a" || cache[dir+"b.pie"].Control.Version != "1.0" {
+		t.Fatalf("Unexpected cache %+v", cache)
+	}
+
+	cache = evalTemplates("test", dir, files, cache)
+	if e := evaluated(); len(e) != 0 {
+		t.Errorf("Unchanged templates should not be evaluated again, got %v", e)
+	}
+	if len(cache) != 2 {
+		t.Errorf("Unchanged templates should stay cached")
+	}
+
+	write("b.pie", template("b", "2.0"))
+	cache = evalTemplates("test", dir, files, cache)
+	if e := evaluated(); len(e) != 1 || e[0] != "b" {
+		t.Errorf("Only the changed template should be evaluated, got %v", e)
+	}
+	if cache[dir+"b.pie"].Control.Version != "2.0" {
+		t.Errorf("Changed template should have been updated")
+	}
+
+	write("default", "desc=shared\n")
+	cache = evalTemplates("test", dir, files, cache)
+	if e := evaluated(); len(e) != 2 {
+		t.Errorf("Changing the default file should evaluate every template, got %v", e)
+	}
+	if cache[dir+"a.pie"].Control.Description != "shared" {
+		t.Errorf("Defaults should have been applied")
+	}
+
+	stale := cache[dir+"a.pie"]
+	stale.Version--
+	cache[dir+"a.pie"] = stale
+	cache = evalTemplates("test", dir, files, cache)
+	if e := evaluated(); len(e) != 1 || e[0] != "a" {
+		t.Errorf("Controls extracted by an older version should be evaluated again, got %v", e)
+	}
+}
@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/helpers/json"
+	"github.com/serenitylinux/libspack/sign"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+//How much a repo's remote packages must be signed before we use them
+type TrustPolicy string
+
+const (
+	TrustNone     TrustPolicy = "none"     //Signatures are ignored
+	TrustOptional TrustPolicy = "optional" //Unsigned repos are used with a warning, a signed repo must check out and stay signed
+	TrustRequired TrustPolicy = "required" //Unsigned repos are refused
+)
+
+func (repo *Repo) trustPolicy() TrustPolicy {
+	if repo.Trust == "" {
+		return TrustOptional
+	}
+	return repo.Trust
+}
+
+//Signed index of the remote packages, nil if the repo is unsigned and its policy allows that
+func (repo *Repo) packagesIndex() (*sign.Index, error) {
+	return repo.loadIndex(repo.packagesDir())
+}
+
+//Signed index of the packages in dir, held to the repo's policy and trust state
+func (repo *Repo) loadIndex(dir string) (*sign.Index, error) {
+	policy := repo.trustPolicy()
+	if policy == TrustNone {
+		return nil, nil
+	}
+
+	state := repo.loadTrustState()
+
+	if !sign.IsSigned(dir) {
+		if policy == TrustRequired {
+			return nil, fmt.Errorf("Repository %v is not signed", repo.Name)
+		}
+		if state.Signed {
+			return nil, fmt.Errorf("Repository %v was signed before but is not anymore, remove %v to accept it unsigned", repo.Name, repo.trustStateFile())
+		}
+		if !repo.warnedUnsigned {
+			log.Warn.Format("Repository %v is not signed", repo.Name)
+			repo.warnedUnsigned = true
+		}
+		return nil, nil
+	}
+
+	keyring, err := sign.LoadKeyring(sign.KeyringDir)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := sign.LoadIndex(dir, keyring.Only(repo.Keys))
+	if err != nil {
+		return nil, fmt.Errorf("Repository %v: %v", repo.Name, err)
+	}
+
+	next, err := state.accept(idx)
+	if err != nil {
+		return nil, fmt.Errorf("Repository %v: %v", repo.Name, err)
+	}
+	if !state.Signed || next.Date.After(state.Date) {
+		repo.saveTrustState(next)
+	}
+	return idx, nil
+}
+
+//Remembered across refreshes so a remote can't quietly drop its signature or
+//serve an index older than one we have already seen
+type trustState struct {
+	Signed bool      //A valid signature has been seen
+	Date   time.Time //Date of the newest valid index
+}
+
+//State after idx, refused if it is older than one already seen
+func (state trustState) accept(idx *sign.Index) (trustState, error) {
+	if idx.Date.Before(state.Date) {
+		return state, fmt.Errorf("index from %v is older than the one from %v already seen", idx.Date, state.Date)
+	}
+	return trustState{Signed: true, Date: idx.Date}, nil
+}
+
+func (repo *Repo) loadTrustState() trustState {
+	var state trustState
+	file := repo.trustStateFile()
+	if !PathExists(file) {
+		return state
+	}
+	if err := json.DecodeFile(file, &state); err != nil {
+		log.Warn.Format("Could not load trust state for repo %s: %s", repo.Name, err)
+	}
+	return state
+}
+
+func (repo *Repo) saveTrustState(state trustState) {
+	if err := json.EncodeFile(repo.trustStateFile(), state); err != nil {
+		log.Warn.Format("Could not save trust state for repo %s: %s", repo.Name, err)
+	}
+}
+
+//Whether a control, pkginfo or spakg from the remote may be used, idx may be nil
+func (repo *Repo) trusted(idx *sign.Index, file string) bool {
+	if idx == nil {
+		return true
+	}
+	if err := idx.Check(file); err != nil {
+		log.Warn.Format("Refusing %v from %v: %v", file, repo.Name, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serenitylinux/libspack/sign"
+)
+
+func TestTrustStateAccept(t *testing.T) {
+	now := time.Now()
+
+	state, err := trustState{}.accept(&sign.Index{Date: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Signed || !state.Date.Equal(now) {
+		t.Errorf("The first valid index should be remembered, got %+v", state)
+	}
+
+	if _, err := state.accept(&sign.Index{Date: now}); err != nil {
+		t.Errorf("The same index should be accepted again: %v", err)
+	}
+
+	newer, err := state.accept(&sign.Index{Date: now.Add(time.Hour)})
+	if err != nil || !newer.Date.Equal(now.Add(time.Hour)) {
+		t.Errorf("A newer index should be accepted and remembered, got %+v (%v)", newer, err)
+	}
+
+	kept, err := newer.accept(&sign.Index{Date: now})
+	if err == nil {
+		t.Errorf("An older index should be refused")
+	}
+	if !kept.Date.Equal(newer.Date) {
+		t.Errorf("A refused index should not change the state, got %+v", kept)
+	}
+}
@@ -0,0 +1,264 @@
+package repo
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+type VerifyResult struct {
+	PkgInfo     *pkginfo.PkgInfo
+	Modified    []string
+	Missing     []string
+	PermChanged []string
+	Config      []string //Modified config files, expected and never restored
+}
+
+func (r VerifyResult) Ok() bool {
+	return len(r.Modified) == 0 && len(r.Missing) == 0 && len(r.PermChanged) == 0
+}
+
+//Re-hashes every file of the package as installed in root
+func (set PkgInstallSet) Verify(root string) VerifyResult {
+	res := VerifyResult{PkgInfo: set.PkgInfo}
+
+	files := make([]string, 0, len(set.Hashes))
+	for file := range set.Hashes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			res.Missing = append(res.Missing, file)
+			continue
+		}
+
+		if !IsSymlink(fi) {
+			unchanged, err := hash.Verify(path, set.Hashes[file])
+			if err != nil || !unchanged {
+				if set.Control.IsConfig(file) {
+					res.Config = append(res.Config, file)
+				} else {
+					res.Modified = append(res.Modified, file)
+				}
+				continue
+			}
+		}
+
+		//Sets from before permissions were recorded only get their content checked
+		if perm, ok := set.Perms[file]; ok {
+			uid, gid := GetUidGid(fi)
+			if perm.Mode != fi.Mode() || perm.Uid != uid || perm.Gid != gid {
+				res.PermChanged = append(res.PermChanged, file)
+			}
+		}
+	}
+	return res
+}
+
+//Restores the damaged files found by Verify from the cached spakg
+func (repo *Repo) Restore(set PkgInstallSet, res VerifyResult, root string) error {
+	return restore(set, res, root, repo.GetSpakgOutput(*set.PkgInfo))
+}
+
+func restore(set PkgInstallSet, res VerifyResult, root string, pkgfile string) error {
+	damaged := append(append([]string{}, res.Modified...), res.Missing...)
+	if len(damaged) != 0 {
+		if err := restoreFiles(set, damaged, root, pkgfile); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range append(damaged, res.PermChanged...) {
+		if perm, ok := set.Perms[file]; ok {
+			path := filepath.Join(root, file)
+			os.Lchown(path, perm.Uid, perm.Gid)
+			if perm.Mode&os.ModeSymlink == 0 {
+				if err := os.Chmod(path, perm.Mode); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func restoreFiles(set PkgInstallSet, files []string, root string, pkgfile string) error {
+	if !PathExists(pkgfile) {
+		return fmt.Errorf("Unable to restore %v, %v is not cached", set.PkgInfo.PrettyString(), pkgfile)
+	}
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := spakg.FromFile(pkgfile, &tmpDir); err != nil {
+		return err
+	}
+	fsDir := tmpDir + "/fs"
+	if err := extractFiles(tmpDir+"/"+spakg.FsName, fsDir, files); err != nil {
+		return fmt.Errorf("Unable to restore %v from %v: %v", set.PkgInfo.PrettyString(), pkgfile, err)
+	}
+
+	for _, file := range files {
+		src := filepath.Join(fsDir, file)
+		dest := filepath.Join(root, file)
+
+		fi, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+		if !IsSymlink(fi) {
+			unchanged, err := hash.Verify(src, set.Hashes[file])
+			if err != nil {
+				return err
+			}
+			if !unchanged {
+				return fmt.Errorf("Cached %v does not match the installed %v", pkgfile, file)
+			}
+		}
+
+		log.Info.Format("Restoring %v", dest)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		os.Remove(dest)
+		if IsSymlink(fi) {
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(target, dest)
+		} else {
+			err = CopyFile(src, dest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Extracts the named members of tarfile into dir. Names are compared cleaned, as
+//fs.tar members are "./usr/bin/x" while hashes are keyed "usr/bin/x"
+func extractFiles(tarfile string, dir string, files []string) error {
+	wanted := make(map[string]bool, len(files))
+	for _, file := range files {
+		wanted[path.Clean(file)] = true
+	}
+	//Hard links whose target was not wanted, by target
+	links := make(map[string][]string)
+
+	err := eachTarEntry(tarfile, func(tr *tar.Reader, hdr *tar.Header, name string) error {
+		if !wanted[name] {
+			return nil
+		}
+		delete(wanted, name)
+
+		dest := filepath.Join(dir, name)
+		if hdr.Typeflag == tar.TypeLink {
+			target := path.Clean(hdr.Linkname)
+			if !PathExists(filepath.Join(dir, target)) {
+				links[target] = append(links[target], dest)
+				return nil
+			}
+			return CopyFile(filepath.Join(dir, target), dest)
+		}
+		return extractEntry(tr, hdr, dest)
+	})
+	if err != nil {
+		return err
+	}
+
+	//The first copy of a hard linked file holds its content
+	if len(links) != 0 {
+		err = eachTarEntry(tarfile, func(tr *tar.Reader, hdr *tar.Header, name string) error {
+			dests, ok := links[name]
+			if !ok {
+				return nil
+			}
+			delete(links, name)
+			if err := extractEntry(tr, hdr, dests[0]); err != nil {
+				return err
+			}
+			for _, dest := range dests[1:] {
+				if err := CopyFile(dests[0], dest); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range wanted {
+		return fmt.Errorf("%v not found in %v", name, spakg.FsName)
+	}
+	for name := range links {
+		return fmt.Errorf("%v not found in %v", name, spakg.FsName)
+	}
+	return nil
+}
+
+func eachTarEntry(tarfile string, fn func(tr *tar.Reader, hdr *tar.Header, name string) error) error {
+	f, err := os.Open(tarfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(tr, hdr, path.Clean(hdr.Name)); err != nil {
+			return err
+		}
+	}
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return os.Symlink(hdr.Linkname, dest)
+	case mode.IsRegular():
+		var copyErr error
+		err := WithFileWriter(dest, true, func(w io.Writer) {
+			_, copyErr = io.Copy(w, tr)
+		})
+		if err != nil {
+			return err
+		}
+		return copyErr
+	default:
+		return fmt.Errorf("Unable to extract %v, unsupported type %v", hdr.Name, mode)
+	}
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+func TestVerify(t *testing.T) {
+	root, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(root+"/usr/bin", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(root+"/etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(hash.HashList)
+	for _, file := range []string{"./usr/bin/app", "./usr/bin/helper", "./usr/bin/tool", "./etc/app.conf"} {
+		if err := ioutil.WriteFile(root+"/"+file, []byte(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := hash.File(root + "/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[file] = sum
+	}
+
+	c := control.Control{Name: "app"}
+	set := NewPkgIS(&c, &pkginfo.PkgInfo{Name: "app"}, hashes, ReasonExplicit)
+	set.recordPerms(root)
+
+	if res := set.Verify(root); !res.Ok() {
+		t.Errorf("Freshly installed package should verify: %+v", res)
+	}
+
+	if err := ioutil.WriteFile(root+"/usr/bin/app", []byte("changed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(root + "/usr/bin/helper"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(root+"/usr/bin/tool", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(root+"/etc/app.conf", []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res := set.Verify(root)
+	if res.Ok() {
+		t.Errorf("Damaged package should not verify")
+	}
+	if !reflect.DeepEqual(res.Modified, []string{"./usr/bin/app"}) {
+		t.Errorf("Unexpected modified files: %v", res.Modified)
+	}
+	if !reflect.DeepEqual(res.Missing, []string{"./usr/bin/helper"}) {
+		t.Errorf("Unexpected missing files: %v", res.Missing)
+	}
+	if !reflect.DeepEqual(res.PermChanged, []string{"./usr/bin/tool"}) {
+		t.Errorf("Unexpected permission changes: %v", res.PermChanged)
+	}
+	if !reflect.DeepEqual(res.Config, []string{"./etc/app.conf"}) {
+		t.Errorf("Unexpected modified configs: %v", res.Config)
+	}
+}
+
+//Spakgs built by forge, with fs.tar members named "./usr/bin/x" and hashes keyed "usr/bin/x"
+func TestRestore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	staging := tmp + "/staging"
+	root := tmp + "/root"
+
+	write := func(dir string) {
+		if err := os.MkdirAll(dir+"/usr/bin", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dir+"/usr/bin/app", []byte("app"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(dir+"/usr/bin/app", dir+"/usr/bin/app-link"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("app", dir+"/usr/bin/app-sym"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(staging)
+	write(root)
+
+	cmd := exec.Command("tar", "-cf", tmp+"/fs.tar", ".")
+	cmd.Dir = staging
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	hashes := make(hash.HashList)
+	for _, file := range []string{"usr/bin/app", "usr/bin/app-link", "usr/bin/app-sym"} {
+		sum, err := hash.File(staging + "/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[file] = sum
+	}
+
+	c := control.Control{Name: "app", Version: "1.0", Iteration: 1}
+	p := pkginfo.FromControl(&c)
+	fsTar, err := os.Open(tmp + "/fs.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsTar.Close()
+	pkgfile := tmp + "/app.spakg"
+	s := spakg.Spakg{Control: c, Pkginfo: *p, Hashes: hashes}
+	if err := s.ToFile(pkgfile, fsTar); err != nil {
+		t.Fatal(err)
+	}
+
+	set := NewPkgIS(&c, p, hashes, ReasonExplicit)
+	set.recordPerms(root)
+	if err := ioutil.WriteFile(root+"/usr/bin/app", []byte("changed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(root + "/usr/bin/app-sym"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := set.Verify(root)
+	if !reflect.DeepEqual(res.Missing, []string{"usr/bin/app-sym"}) || len(res.Modified) != 2 {
+		t.Fatalf("Unexpected damage %+v", res)
+	}
+	if err := restore(*set, res, root, pkgfile); err != nil {
+		t.Fatal(err)
+	}
+	if res := set.Verify(root); !res.Ok() {
+		t.Errorf("Restored package should verify: %+v", res)
+	}
+	if target, err := os.Readlink(root + "/usr/bin/app-sym"); err != nil || target != "app" {
+		t.Errorf("Symlink should have been restored, got %q (%v)", target, err)
+	}
+}
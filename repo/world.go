@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/serenitylinux/libspack/misc"
+)
+
+const WorldFile = "/var/lib/spack/world" //Packages explicitly requested in a root
+
+//Set of package names explicitly requested in a root
+type World map[string]bool
+
+func LoadWorld(root string) (World, error) {
+	world := make(World)
+	file := root + WorldFile
+	if !misc.PathExists(file) {
+		return world, nil
+	}
+
+	var interr error
+	err := misc.WithFileReader(file, func(r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			name := strings.TrimSpace(scanner.Text())
+			if len(name) != 0 {
+				world[name] = true
+			}
+		}
+		interr = scanner.Err()
+	})
+	if interr != nil {
+		return nil, interr
+	}
+	return world, err
+}
+
+func (w World) Add(name string) {
+	w[name] = true
+}
+
+func (w World) Remove(name string) {
+	delete(w, name)
+}
+
+func (w World) Contains(name string) bool {
+	return w[name]
+}
+
+func (w World) Save(root string) error {
+	names := make([]string, 0, len(w))
+	for name := range w {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := os.MkdirAll(filepath.Dir(root+WorldFile), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name + "\n")
+	}
+	return misc.WriteFileAtomic(root+WorldFile, &buf)
+}
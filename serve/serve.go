@@ -0,0 +1,263 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/repo"
+	"github.com/serenitylinux/libspack/sign"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+const DefaultMaxUpload = 1 << 30
+
+type Options struct {
+	Username  string //Basic auth is required if set
+	Password  string
+	Uploads   bool  //Accept spakgs with PUT /pkgs/, only allowed with auth
+	MaxUpload int64 //Largest accepted spakg, DefaultMaxUpload if 0
+}
+
+//Serves a directory of spakgs, such as a repo's SpakgDir, as an http remote.
+//The info files, packages.list and bundle are generated from the spakgs and
+//rebuilt whenever one of them is added, removed or changed
+type Server struct {
+	dir  string
+	opts Options
+
+	lock   sync.Mutex
+	spakgs map[string]served //Spakgs the index below was built from, by file name
+	stamp  time.Time         //When the index below was built
+	info   map[string][]byte
+	list   []byte
+	bund   []byte
+}
+
+//A spakg with the same size and mtime is assumed unchanged and not reopened
+type served struct {
+	size    int64
+	modTime time.Time
+	spkg    *spakg.Spakg //nil if it could not be read
+}
+
+//Uploaded spakgs land in dir, which clients and possibly the host itself
+//trust, so uploads are refused unless only known users can make them
+func NewServer(dir string, opts Options) (*Server, error) {
+	if opts.Uploads && opts.Username == "" {
+		return nil, fmt.Errorf("Uploads require a username and password")
+	}
+	if opts.MaxUpload == 0 {
+		opts.MaxUpload = DefaultMaxUpload
+	}
+	return &Server{dir: dir, opts: opts}, nil
+}
+
+func ListenAndServe(addr string, dir string, opts Options) error {
+	s, err := NewServer(dir, opts)
+	if err != nil {
+		return err
+	}
+	log.Info.Format("Serving %s on %s", dir, addr)
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="spack"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case "GET", "HEAD":
+		s.get(w, r, path)
+	case "PUT":
+		if !s.opts.Uploads {
+			http.Error(w, "Repository is read-only", http.StatusForbidden)
+			return
+		}
+		s.put(w, r, path)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.opts.Username == "" {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.opts.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.opts.Password)) == 1
+}
+
+//Only file names are taken from the request, nothing outside dir is ever served
+func fileName(path string, prefix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(path, prefix)
+	return name, name != "" && !strings.Contains(name, "/") && name != "." && name != ".."
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, path string) {
+	if name, ok := fileName(path, repo.PkgsDir+"/"); ok && strings.HasSuffix(name, ".spakg") {
+		//ServeFile handles the range requests fetches resume with
+		http.ServeFile(w, r, filepath.Join(s.dir, name))
+		return
+	}
+	if path == sign.IndexName || path == sign.SignatureName {
+		http.ServeFile(w, r, filepath.Join(s.dir, path))
+		return
+	}
+
+	if err := s.refresh(); err != nil {
+		log.Warn.Format("Unable to index %s: %s", s.dir, err)
+		http.Error(w, "Unable to index repository", http.StatusInternalServerError)
+		return
+	}
+	s.lock.Lock()
+	var data []byte
+	switch path {
+	case repo.PackageListName:
+		data = s.list
+	case repo.BundleName:
+		data = s.bund
+	default:
+		if name, ok := fileName(path, repo.InfoDir+"/"); ok {
+			data = s.info[name]
+		}
+	}
+	stamp := s.stamp
+	s.lock.Unlock()
+
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, path, stamp, bytes.NewReader(data))
+}
+
+//Rebuilds the index if any spakg in dir changed since it was last built. The
+//directory's own mtime misses a spakg overwritten in place
+func (s *Server) refresh() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := make(map[string]served)
+	changed := s.list == nil
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".spakg") {
+			continue
+		}
+		prev, ok := s.spakgs[f.Name()]
+		if ok && prev.size == f.Size() && prev.modTime.Equal(f.ModTime()) {
+			current[f.Name()] = prev
+			continue
+		}
+		changed = true
+		spkg, err := spakg.FromFile(filepath.Join(s.dir, f.Name()), nil)
+		if err != nil {
+			//Remembered so it is not reopened until it changes
+			log.Warn.Format("Skipping %s: %s", f.Name(), err)
+		}
+		current[f.Name()] = served{f.Size(), f.ModTime(), spkg}
+	}
+	if !changed && len(current) == len(s.spakgs) {
+		return nil
+	}
+
+	spkgs := make([]*spakg.Spakg, 0, len(current))
+	for _, sv := range current {
+		if sv.spkg != nil {
+			spkgs = append(spkgs, sv.spkg)
+		}
+	}
+
+	info, err := repo.InfoFiles(spkgs)
+	if err != nil {
+		return err
+	}
+	bund, err := repo.Bundle(info)
+	if err != nil {
+		return err
+	}
+	list, err := repo.PackageListOf(info, bund)
+	if err != nil {
+		return err
+	}
+	listData, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	s.spakgs, s.info, s.bund, s.list, s.stamp = current, info, bund, listData, time.Now()
+	log.Debug.Format("Indexed %d spakgs in %s", len(spkgs), s.dir)
+	return nil
+}
+
+//Accepts a spakg, stored under the name its pkginfo gives it
+func (s *Server) put(w http.ResponseWriter, r *http.Request, path string) {
+	if _, ok := fileName(path, repo.PkgsDir+"/"); !ok || !strings.HasSuffix(path, ".spakg") {
+		http.Error(w, "Only spakgs can be uploaded to "+repo.PkgsDir+"/", http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".upload")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, s.opts.MaxUpload))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Spakg too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	spkg, err := spakg.FromFile(tmp.Name(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid spakg: %s", err), http.StatusBadRequest)
+		return
+	}
+	dest := filepath.Join(s.dir, spkg.Pkginfo.String()+".spakg")
+	//Unlike rename, link never replaces a spakg clients may already have
+	if err := os.Link(tmp.Name(), dest); err != nil {
+		if os.IsExist(err) {
+			http.Error(w, spkg.Pkginfo.PrettyString()+" already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	os.Chmod(dest, 0644)
+	log.Info.Format("Received %s", spkg.Pkginfo.PrettyString())
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,173 @@
+package serve
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+	"github.com/serenitylinux/libspack/repo"
+	"github.com/serenitylinux/libspack/spakg"
+)
+
+func spakgBytes(t *testing.T, name string) ([]byte, *spakg.Spakg) {
+	c := control.Control{Name: name, Version: "1.0", Iteration: 1}
+	s := &spakg.Spakg{Control: c, Pkginfo: *pkginfo.FromControl(&c), Hashes: make(hash.HashList)}
+	buf := new(bytes.Buffer)
+	if err := s.ToWriter(buf, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), s
+}
+
+func request(t *testing.T, method string, url string, body []byte, auth bool) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth {
+		req.SetBasicAuth("build", "secret")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, app := spakgBytes(t, "app")
+	ioutil.WriteFile(filepath.Join(dir, app.Pkginfo.String()+".spakg"), data, 0644)
+
+	srv, err := NewServer(dir, Options{Username: "build", Password: "secret", Uploads: true, MaxUpload: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	if resp := request(t, "GET", server.URL+"/"+repo.PackageListName, nil, false); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected auth to be required, got %v", resp.Status)
+	}
+	if resp := request(t, "GET", server.URL+"/"+repo.PackageListName, nil, true); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the list, got %v", resp.Status)
+	}
+	if resp := request(t, "GET", server.URL+"/info/"+app.Pkginfo.String()+".pkginfo", nil, true); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the pkginfo, got %v", resp.Status)
+	}
+	if resp := request(t, "GET", server.URL+"/pkgs/../../etc/passwd", nil, true); resp.StatusCode == http.StatusOK {
+		t.Errorf("Files outside the repo should not be served")
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/"+repo.PkgsDir+"/"+app.Pkginfo.String()+".spakg", nil)
+	req.SetBasicAuth("build", "secret")
+	req.Header.Set("Range", "bytes=10-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[10:]) {
+		t.Errorf("Expected the rest of the spakg, got %v", resp.Status)
+	}
+
+	libData, lib := spakgBytes(t, "lib")
+	if resp := request(t, "PUT", server.URL+"/"+repo.PkgsDir+"/upload.spakg", libData, true); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected the upload to be accepted, got %v", resp.Status)
+	}
+	if resp := request(t, "GET", server.URL+"/info/"+lib.Pkginfo.String()+".pkginfo", nil, true); resp.StatusCode != http.StatusOK {
+		t.Errorf("Uploaded spakg should be indexed, got %v", resp.Status)
+	}
+	if resp := request(t, "PUT", server.URL+"/"+repo.PkgsDir+"/upload.spakg", libData, true); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Existing spakgs should not be replaced, got %v", resp.Status)
+	}
+	if resp := request(t, "PUT", server.URL+"/"+repo.PkgsDir+"/bad.spakg", []byte("junk"), true); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid spakgs should be refused, got %v", resp.Status)
+	}
+	if resp := request(t, "PUT", server.URL+"/"+repo.PkgsDir+"/big.spakg", make([]byte, 2<<20), true); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized uploads should be refused, got %v", resp.Status)
+	}
+}
+
+func TestServeReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewServer(dir, Options{Uploads: true}); err == nil {
+		t.Errorf("Uploads without auth should be refused")
+	}
+
+	//Read-only unless uploads are asked for
+	srv, err := NewServer(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	data, _ := spakgBytes(t, "app")
+	if resp := request(t, "PUT", server.URL+"/"+repo.PkgsDir+"/app.spakg", data, false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Read-only server should refuse uploads, got %v", resp.Status)
+	}
+}
+
+func TestServeOverwrittenInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, app := spakgBytes(t, "app")
+	file := filepath.Join(dir, app.Pkginfo.String()+".spakg")
+	ioutil.WriteFile(file, data, 0644)
+
+	srv, err := NewServer(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := func() string {
+		if err := srv.refresh(); err != nil {
+			t.Fatal(err)
+		}
+		return string(srv.list)
+	}
+	before := list()
+
+	//Same name, rebuilt with a file, written over the old one
+	app.Hashes["./usr/bin/app"] = "sha256:00"
+	buf := new(bytes.Buffer)
+	if err := app.ToWriter(buf, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(dir)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(buf.Bytes())
+	f.Close()
+	os.Chtimes(dir, fi.ModTime(), fi.ModTime())
+
+	if after := list(); after == before {
+		t.Errorf("A spakg overwritten in place should be indexed again")
+	}
+}
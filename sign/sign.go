@@ -0,0 +1,280 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/serenitylinux/libspack/hash"
+)
+
+import . "github.com/serenitylinux/libspack/misc"
+
+const (
+	KeyringDir    = "/etc/spack/keys/" //Trusted public keys, one <name>.pub per key
+	IndexName     = "index.json"
+	SignatureName = "index.json.sig"
+)
+
+//The only digest an index may pin files by, a collision prone one would let a
+//different file match what was signed
+const IndexAlgorithm = hash.SHA256
+
+//Files covered by an index
+var IndexedRegex = regexp.MustCompile(".*\\.(control|pkginfo|files|spakg)$")
+
+//Digests of every control, pkginfo and spakg a repository publishes, keyed by file name
+type Index struct {
+	Date  time.Time
+	Files map[string]string
+}
+
+//Detached signature over the exact bytes of an index
+type Signature struct {
+	Key       string //Name of the key in the keyring
+	Signature string //Base64
+}
+
+type Keyring map[string]ed25519.PublicKey
+
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+func encodeKey(key []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+}
+
+func decodeKey(filename string, size int) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key %v: %v", filename, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("Invalid key %v: wrong size", filename)
+	}
+	return key, nil
+}
+
+func SavePublicKey(filename string, key ed25519.PublicKey) error {
+	return ioutil.WriteFile(filename, encodeKey(key), 0644)
+}
+
+func SavePrivateKey(filename string, key ed25519.PrivateKey) error {
+	return ioutil.WriteFile(filename, encodeKey(key), 0600)
+}
+
+func LoadPublicKey(filename string) (ed25519.PublicKey, error) {
+	key, err := decodeKey(filename, ed25519.PublicKeySize)
+	return ed25519.PublicKey(key), err
+}
+
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	key, err := decodeKey(filename, ed25519.PrivateKeySize)
+	return ed25519.PrivateKey(key), err
+}
+
+//Every <name>.pub in dir, a missing dir is an empty keyring
+func LoadKeyring(dir string) (Keyring, error) {
+	keyring := make(Keyring)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return keyring, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".pub") {
+			continue
+		}
+		key, err := LoadPublicKey(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keyring[strings.TrimSuffix(f.Name(), ".pub")] = key
+	}
+	return keyring, nil
+}
+
+//Only the named keys, or the whole keyring if names is empty
+func (k Keyring) Only(names []string) Keyring {
+	if len(names) == 0 {
+		return k
+	}
+	res := make(Keyring)
+	for _, name := range names {
+		if key, ok := k[name]; ok {
+			res[name] = key
+		}
+	}
+	return res
+}
+
+func (k Keyring) Verify(data []byte, sig Signature) error {
+	key, ok := k[sig.Key]
+	if !ok {
+		return fmt.Errorf("Signed with untrusted key %v", sig.Key)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return fmt.Errorf("Invalid signature: %v", err)
+	}
+	if !ed25519.Verify(key, data, raw) {
+		return fmt.Errorf("Bad signature from key %v", sig.Key)
+	}
+	return nil
+}
+
+func Sign(data []byte, name string, key ed25519.PrivateKey) Signature {
+	return Signature{name, base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))}
+}
+
+//Hashes every control, pkginfo and spakg under dir
+func NewIndex(dir string) (*Index, error) {
+	idx := &Index{time.Now(), make(map[string]string)}
+	walk := func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() || !IndexedRegex.MatchString(path) {
+			return nil
+		}
+		name := filepath.Base(path)
+		if _, exists := idx.Files[name]; exists {
+			return fmt.Errorf("Duplicate %v in %v", name, dir)
+		}
+		sum, err := hash.Sum(IndexAlgorithm, path)
+		if err != nil {
+			return err
+		}
+		idx.Files[name] = sum
+		return nil
+	}
+	if err := filepath.Walk(dir, walk); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+//Indexes and signs everything under dir, writing the index and its signature next to it
+func SignDir(dir string, name string, key ed25519.PrivateKey) error {
+	idx, err := NewIndex(dir)
+	if err != nil {
+		return err
+	}
+	signed, err := idx.Sign(name, key)
+	if err != nil {
+		return err
+	}
+	return signed.Write(dir)
+}
+
+//An index and its signature, ready to be written out
+type Signed struct {
+	Index     []byte
+	Signature []byte
+}
+
+func (idx *Index) Sign(name string, key ed25519.PrivateKey) (*Signed, error) {
+	for file, sum := range idx.Files {
+		if err := checkAlgorithm(file, sum); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := json.Marshal(Sign(data, name, key))
+	if err != nil {
+		return nil, err
+	}
+	return &Signed{data, sig}, nil
+}
+
+//Signature last, a reader seeing the new index with the old signature refuses both
+func (s *Signed) Write(dir string) error {
+	if err := WriteFileAtomic(filepath.Join(dir, IndexName), bytes.NewReader(s.Index)); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, SignatureName), bytes.NewReader(s.Signature))
+}
+
+//Whether dir has an index at all
+func IsSigned(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, IndexName))
+	return err == nil
+}
+
+//Loads the index in dir, refusing it unless it is signed by a key in keyring
+func LoadIndex(dir string, keyring Keyring) (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IndexName))
+	if err != nil {
+		return nil, err
+	}
+	sigData, err := ioutil.ReadFile(filepath.Join(dir, SignatureName))
+	if err != nil {
+		return nil, fmt.Errorf("Index in %v is not signed: %v", dir, err)
+	}
+
+	var sig Signature
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		return nil, fmt.Errorf("Invalid signature in %v: %v", dir, err)
+	}
+	if err := keyring.Verify(data, sig); err != nil {
+		return nil, fmt.Errorf("Index in %v: %v", dir, err)
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	for name, sum := range idx.Files {
+		if err := checkAlgorithm(name, sum); err != nil {
+			return nil, fmt.Errorf("Index in %v: %v", dir, err)
+		}
+	}
+	return &idx, nil
+}
+
+func checkAlgorithm(name string, sum string) error {
+	if a, _ := hash.Split(sum); a != IndexAlgorithm {
+		return fmt.Errorf("%v is indexed by %v, only %v is accepted", name, a, IndexAlgorithm)
+	}
+	return nil
+}
+
+//Whether file matches its digest in the index
+func (idx *Index) Check(file string) error {
+	name := filepath.Base(file)
+	expected, ok := idx.Files[name]
+	if !ok {
+		return fmt.Errorf("%v is not in the signed index", name)
+	}
+	if err := checkAlgorithm(name, expected); err != nil {
+		return err
+	}
+	ok, err := hash.Verify(file, expected)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%v does not match the signed index", name)
+	}
+	return nil
+}
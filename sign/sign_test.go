@@ -0,0 +1,127 @@
+package sign
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "pkgs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	control := filepath.Join(dir, "app-1.0.control")
+	spakg := filepath.Join(dir, "pkgs", "app-1.0_1.spakg")
+	ioutil.WriteFile(control, []byte("control"), 0644)
+	ioutil.WriteFile(spakg, []byte("spakg"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "packages.list"), []byte("[]"), 0644)
+
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignDir(dir, "release", priv); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := LoadIndex(dir, Keyring{"release": pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Files) != 2 {
+		t.Errorf("Expected the control and spakg to be indexed, got %v", idx.Files)
+	}
+	if err := idx.Check(control); err != nil {
+		t.Error(err)
+	}
+	if err := idx.Check(spakg); err != nil {
+		t.Error(err)
+	}
+
+	ioutil.WriteFile(spakg, []byte("tampered"), 0644)
+	if err := idx.Check(spakg); err == nil {
+		t.Errorf("Tampered spakg should not check out")
+	}
+	if err := idx.Check(filepath.Join(dir, "other-1.0.control")); err == nil {
+		t.Errorf("Files missing from the index should not check out")
+	}
+
+	otherPub, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(dir, Keyring{"release": otherPub}); err == nil {
+		t.Errorf("Index signed by another key should be refused")
+	}
+	if _, err := LoadIndex(dir, Keyring{}); err == nil {
+		t.Errorf("Index signed by an unknown key should be refused")
+	}
+
+	weak := &Index{Files: map[string]string{"a.spakg": "md5:d41d8cd98f00b204e9800998ecf8427e"}}
+	if _, err := weak.Sign("release", priv); err == nil {
+		t.Errorf("Signing an md5 digest should be refused")
+	}
+	for _, sum := range []string{"md5:d41d8cd98f00b204e9800998ecf8427e", "d41d8cd98f00b204e9800998ecf8427e"} {
+		data, _ := json.Marshal(&Index{Files: map[string]string{"a.spakg": sum}})
+		sig, _ := json.Marshal(Sign(data, "release", priv))
+		weakDir, _ := ioutil.TempDir("", "weak")
+		defer os.RemoveAll(weakDir)
+		(&Signed{data, sig}).Write(weakDir)
+		if _, err := LoadIndex(weakDir, Keyring{"release": pub}); err == nil {
+			t.Errorf("Index pinning %s should be refused", sum)
+		}
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, IndexName))
+	data[len(data)-2] ^= 1
+	ioutil.WriteFile(filepath.Join(dir, IndexName), data, 0644)
+	if _, err := LoadIndex(dir, Keyring{"release": pub}); err == nil {
+		t.Errorf("Modified index should be refused")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePublicKey(filepath.Join(dir, "release.pub"), pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePrivateKey(filepath.Join(dir, "release.key"), priv); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyring) != 1 || !keyring["release"].Equal(pub) {
+		t.Errorf("Unexpected keyring %v", keyring)
+	}
+	if len(keyring.Only([]string{"other"})) != 0 {
+		t.Errorf("Only should drop keys not named")
+	}
+
+	loaded, err := LoadPrivateKey(filepath.Join(dir, "release.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Verify([]byte("data"), Sign([]byte("data"), "release", loaded)); err != nil {
+		t.Error(err)
+	}
+}
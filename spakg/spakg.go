@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"time"
 
 	"github.com/serenitylinux/libspack/control"
@@ -20,7 +21,8 @@ const (
 	ControlName    = "pkg.control"
 	PkginfoName    = "pkginfo.txt"
 	TemplateName   = "pkg.template"
-	Md5sumsName    = "md5sums.txt"
+	HashesName     = "hashes.json"
+	Md5sumsName    = "md5sums.txt" //Read from spakgs built before hashes were tagged
 	PkgInstallName = "pkginstall.sh"
 	FsName         = "fs.tar"
 )
@@ -28,9 +30,10 @@ const (
 type Spakg struct {
 	Pkginfo    pkginfo.PkgInfo
 	Control    control.Control
-	Md5sums    hash.HashList
+	Hashes     hash.HashList
 	Pkginstall string
 	Template   string
+	Links      []string //Symlinks and hard links in fs.tar, which have no hash
 }
 
 func (s *Spakg) ToFile(filename string, fsReader io.Reader) (err error) {
@@ -94,7 +97,7 @@ func (s *Spakg) ToWriter(writer io.Writer, fsReader io.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	err = writeTarJSON(tw, Md5sumsName, s.Md5sums)
+	err = writeTarJSON(tw, HashesName, s.Hashes)
 	if err != nil {
 		return
 	}
@@ -129,7 +132,7 @@ func FromReader(reader io.Reader, tarname *string) (*Spakg, error) {
 	foundFs := false
 	//	foundTemplate := false
 	foundPkginstall := false
-	foundmd5sum := false
+	foundHashes := false
 
 	for {
 		hdr, err := tr.Next()
@@ -159,20 +162,27 @@ func FromReader(reader io.Reader, tarname *string) (*Spakg, error) {
 		case PkgInstallName:
 			s.Pkginstall = misc.ReaderToString(tr)
 			foundPkginstall = true
-		case Md5sumsName:
-			err = decoder.Decode(&s.Md5sums)
+		case HashesName, Md5sumsName:
+			err = decoder.Decode(&s.Hashes)
 			if err != nil {
 				return nil, err
 			}
-			foundmd5sum = true
+			foundHashes = true
 		case FsName:
+			var linkErr error
 			if tarname != nil {
-				err := misc.WithFileWriter(*tarname+"/"+FsName, true, func(fsw io.Writer) {
-					io.Copy(fsw, tr)
+				err = misc.WithFileWriter(*tarname+"/"+FsName, true, func(fsw io.Writer) {
+					s.Links, linkErr = fsLinks(io.TeeReader(tr, fsw))
+					io.Copy(fsw, tr) //Padding after the last entry
 				})
 				if err != nil {
 					return nil, err
 				}
+			} else {
+				s.Links, linkErr = fsLinks(tr)
+			}
+			if linkErr != nil {
+				return nil, fmt.Errorf("Invalid Spakg, bad %s: %v", FsName, linkErr)
 			}
 			foundFs = true
 		default:
@@ -180,10 +190,82 @@ func FromReader(reader io.Reader, tarname *string) (*Spakg, error) {
 		}
 	}
 	//Template may not be nessesary
-	if foundControl && foundPkginfo && foundFs && foundPkginstall && foundmd5sum {
+	if foundControl && foundPkginfo && foundFs && foundPkginstall && foundHashes {
 		return &s, nil
 	} else {
 		//TODO what file is missing
 		return nil, errors.New("Invalid Spakg, missing files")
 	}
 }
+
+//Symlinks and hard links in fs, named like the hashes ("usr/bin/x")
+func fsLinks(fs io.Reader) ([]string, error) {
+	links := make([]string, 0)
+	tr := tar.NewReader(fs)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			links = append(links, path.Clean(hdr.Name))
+		}
+	}
+}
+
+//Control and pkginfo of a spakg, which are written before its other files
+type Metadata struct {
+	Control control.Control
+	Pkginfo pkginfo.PkgInfo
+}
+
+func MetadataFromFile(filename string) (*Metadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return MetadataFromReader(file)
+}
+
+//Stops after the control and pkginfo, so the rest of the spakg is not checked
+func MetadataFromReader(reader io.Reader) (*Metadata, error) {
+	var m Metadata
+	tr := tar.NewReader(reader)
+	foundControl := false
+	foundPkginfo := false
+
+	for !foundControl || !foundPkginfo {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("Invalid Spakg, missing files")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Name {
+		case ControlName:
+			if err := json.NewDecoder(tr).Decode(&m.Control); err != nil {
+				return nil, err
+			}
+			foundControl = true
+		case PkginfoName:
+			if err := json.NewDecoder(tr).Decode(&m.Pkginfo); err != nil {
+				return nil, err
+			}
+			foundPkginfo = true
+		case TemplateName, PkgInstallName, HashesName, Md5sumsName:
+			//Skipped
+		case FsName:
+			return nil, errors.New("Invalid Spakg, missing files")
+		default:
+			return nil, errors.New(fmt.Sprintf("Invalid Spakg, contains %s", hdr.Name))
+		}
+	}
+	return &m, nil
+}
@@ -0,0 +1,38 @@
+package spakg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/serenitylinux/libspack/control"
+	"github.com/serenitylinux/libspack/hash"
+	"github.com/serenitylinux/libspack/pkginfo"
+)
+
+func TestMetadataFromReader(t *testing.T) {
+	c := control.Control{Name: "app", Version: "1.0", Iteration: 1}
+	s := Spakg{Control: c, Pkginfo: *pkginfo.FromControl(&c), Hashes: make(hash.HashList)}
+	var buf bytes.Buffer
+	if err := s.ToWriter(&buf, strings.NewReader("fs")); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := MetadataFromReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Control.Name != "app" || m.Pkginfo.String() != s.Pkginfo.String() {
+		t.Errorf("Unexpected metadata %+v", m)
+	}
+
+	//Never reads far enough to notice a truncated fs.tar
+	truncated := buf.Bytes()[:buf.Len()/2]
+	if _, err := MetadataFromReader(bytes.NewReader(truncated)); err != nil {
+		t.Errorf("Metadata should only need the header entries: %v", err)
+	}
+
+	if _, err := MetadataFromReader(strings.NewReader("")); err == nil {
+		t.Errorf("Empty spakg should fail")
+	}
+}
@@ -7,6 +7,7 @@ package spdl
 
 PkgName:
 all except "<>=("
+repo::pkgname pins the package to a single repo
 
 Version:
 >=version (multiple possible)
@@ -31,8 +32,11 @@ import (
 	"github.com/serenitylinux/libspack/parser"
 )
 
+const RepoSeparator = "::"
+
 type Dep struct {
 	Condition *ExprList
+	Repo      string //Only this repo may provide the package, any if empty
 	Name      string
 	Version1  *Version
 	Version2  *Version
@@ -44,6 +48,9 @@ func (d *Dep) String() string {
 	if d.Condition != nil {
 		res = "[" + d.Condition.String() + "]"
 	}
+	if d.Repo != "" {
+		res += d.Repo + RepoSeparator
+	}
 	res += d.Name + d.Version1.String() + d.Version2.String()
 	if d.Flags != nil {
 		res += "(" + d.Flags.String() + ")"
@@ -130,6 +137,13 @@ func (d *Dep) parse(in *parser.Input) error {
 	}
 
 	d.Name = in.ReadUntill("<>=()")
+	if i := strings.Index(d.Name, RepoSeparator); i >= 0 {
+		d.Repo = d.Name[:i]
+		d.Name = d.Name[i+len(RepoSeparator):]
+		if len(d.Repo) == 0 {
+			return errors.New("Must specify a repo before '" + RepoSeparator + "'")
+		}
+	}
 	if len(d.Name) == 0 {
 		return errors.New("Must specify dep package name")
 	}
@@ -148,6 +148,28 @@ func TestDepParse(t *testing.T) {
 				),
 			},
 		},
+		{
+			name:  "Repo",
+			input: "main::basic>=3",
+			expect: Dep{
+				Repo: "main",
+				Name: "basic",
+				Version1: &Version{
+					typ: GT,
+					ver: "3",
+				},
+			},
+		},
+		{
+			name:  "Missing Repo",
+			input: "::basic",
+			err:   true,
+		},
+		{
+			name:  "Missing Name After Repo",
+			input: "main::",
+			err:   true,
+		},
 		{
 			name:   "ALL THE THINGS!",
 			input:  fulldepJSON,
@@ -0,0 +1,215 @@
+/*
+Transaction journals every file replaced or removed in a root so a failed
+install can be rolled back.  Files are moved into the journal directory before
+they are touched, and the journal itself is appended and synced before each
+move so a crash at any point leaves enough behind for Recover.
+*/
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/misc"
+)
+
+const JournalDir = "/var/lib/spack/journal/"
+
+const (
+	journalName = "journal"
+	filesDir    = "files/"
+)
+
+type entry struct {
+	Path   string
+	Backup string //Empty if Path did not exist before the transaction
+}
+
+type Transaction struct {
+	dir     string
+	journal *os.File
+	entries []entry
+	seen    map[string]bool
+}
+
+func Begin(root string) (*Transaction, error) {
+	dir := filepath.Clean(root+JournalDir) + "/"
+	if misc.PathExists(dir + journalName) {
+		return nil, fmt.Errorf("Unfinished transaction in %v, it must be recovered first", root)
+	}
+	//Leftovers from a finished commit
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir+filesDir, 0755); err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(dir+journalName, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{
+		dir:     dir,
+		journal: journal,
+		seen:    make(map[string]bool),
+	}, nil
+}
+
+//Must be called before path is replaced, removed or created
+//A nil Transaction does not journal anything
+func (t *Transaction) Backup(path string) error {
+	if t == nil {
+		return nil
+	}
+
+	path = filepath.Clean(path)
+	if t.seen[path] {
+		return nil //Only the state from before the transaction matters
+	}
+
+	e := entry{Path: path}
+	fi, err := os.Lstat(path)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil //Directories are only ever created, never replaced
+	case err == nil:
+		e.Backup = fmt.Sprintf("%s%s%d", t.dir, filesDir, len(t.entries))
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if err := json.NewEncoder(t.journal).Encode(e); err != nil {
+		return err
+	}
+	if err := t.journal.Sync(); err != nil {
+		return err
+	}
+	t.seen[path] = true
+	t.entries = append(t.entries, e)
+
+	if e.Backup != "" {
+		log.Debug.Format("Journal %v", path)
+		return moveFile(path, e.Backup)
+	}
+	return nil
+}
+
+func (t *Transaction) Commit() error {
+	if t == nil {
+		return nil
+	}
+	t.journal.Close()
+	//Removing the journal is the commit point
+	if err := os.Remove(t.dir + journalName); err != nil {
+		return err
+	}
+	return os.RemoveAll(t.dir)
+}
+
+func (t *Transaction) Rollback() error {
+	if t == nil {
+		return nil
+	}
+	t.journal.Close()
+	return rollback(t.dir, t.entries)
+}
+
+//Rolls back a transaction left behind by a crash, if any
+func Recover(root string) error {
+	dir := filepath.Clean(root+JournalDir) + "/"
+	if !misc.PathExists(dir + journalName) {
+		return nil
+	}
+
+	log.Warn.Format("Rolling back unfinished transaction in %v", root)
+
+	entries := make([]entry, 0)
+	err := misc.WithFileReader(dir+journalName, func(r io.Reader) {
+		dec := json.NewDecoder(r)
+		for {
+			var e entry
+			//A crash may leave a partial last entry, whose file was never moved
+			if dec.Decode(&e) != nil {
+				return
+			}
+			entries = append(entries, e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return rollback(dir, entries)
+}
+
+func rollback(dir string, entries []entry) error {
+	var err error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Backup == "" {
+			//Created during the transaction, directories are only removed if empty
+			if rerr := os.Remove(e.Path); rerr != nil && !os.IsNotExist(rerr) {
+				log.Debug.Format("Leaving %v: %v", e.Path, rerr)
+			}
+			continue
+		}
+
+		if _, lerr := os.Lstat(e.Backup); lerr != nil {
+			continue //Crashed before the original was moved
+		}
+		log.Debug.Format("Restoring %v", e.Path)
+		os.Remove(e.Path)
+		if merr := moveFile(e.Backup, e.Path); merr != nil {
+			log.Error.Format("Unable to restore %v: %v", e.Path, merr)
+			err = merr
+		}
+	}
+	if err != nil {
+		return err //Keep the journal so the rest can be restored by hand
+	}
+
+	if err := os.Remove(dir + journalName); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func moveFile(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if os.Rename(src, dest) == nil {
+		return nil
+	}
+
+	//Different filesystems
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if misc.IsSymlink(fi) {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dest); err != nil {
+			return err
+		}
+	} else {
+		if err := misc.CopyFile(src, dest); err != nil {
+			return err
+		}
+		uid, gid := misc.GetUidGid(fi)
+		os.Lchown(dest, uid, gid)
+		if err := os.Chmod(dest, fi.Mode()); err != nil {
+			return err
+		}
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,118 @@
+package transaction
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/serenitylinux/libspack/misc"
+)
+
+func setupRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(root+"/replaced", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(root+"/removed", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func change(t *testing.T, tx *Transaction, root string) {
+	for _, f := range []string{"/replaced", "/removed", "/dir", "/dir/created"} {
+		if err := tx.Backup(root + f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(root+"/replaced", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(root + "/removed")
+	if err := os.Mkdir(root+"/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(root+"/dir/created", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRestored(t *testing.T, root string) {
+	for _, f := range []string{"/replaced", "/removed"} {
+		data, err := ioutil.ReadFile(root + f)
+		if err != nil || string(data) != "old" {
+			t.Errorf("%v was not restored: %q %v", f, data, err)
+		}
+	}
+	if misc.PathExists(root + "/dir") {
+		t.Errorf("Created files were not removed")
+	}
+	if misc.PathExists(root + JournalDir) {
+		t.Errorf("Journal was left behind")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	root := setupRoot(t)
+	defer os.RemoveAll(root)
+
+	tx, err := Begin(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(t, tx, root)
+
+	if _, err := Begin(root); err == nil {
+		t.Errorf("Only one transaction may run in a root")
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, root)
+}
+
+func TestRecover(t *testing.T) {
+	root := setupRoot(t)
+	defer os.RemoveAll(root)
+
+	tx, err := Begin(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(t, tx, root)
+	tx.journal.Close() //Crash
+
+	if err := Recover(root); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, root)
+}
+
+func TestCommit(t *testing.T) {
+	root := setupRoot(t)
+	defer os.RemoveAll(root)
+
+	tx, err := Begin(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(t, tx, root)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Recover(root); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(root + "/replaced")
+	if err != nil || string(data) != "new" {
+		t.Errorf("Committed change was lost: %q %v", data, err)
+	}
+	if misc.PathExists(root + JournalDir) {
+		t.Errorf("Journal was left behind")
+	}
+}
@@ -0,0 +1,63 @@
+package libspack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cam72cam/go-lumberjack/log"
+	"github.com/serenitylinux/libspack/lock"
+	"github.com/serenitylinux/libspack/repo"
+)
+
+//Checks the installed files of the named packages, or of every package in root if none are given.
+//With restore, damaged files are replaced from the cached spakgs
+func Verify(names []string, root string, restore bool) ([]repo.VerifyResult, error) {
+	var rootLock *lock.Lock
+	var err error
+	if restore {
+		rootLock, err = lock.Exclusive(root)
+	} else {
+		rootLock, err = lock.Shared(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rootLock.Release()
+
+	if restore {
+		if err := recoverRoot(root); err != nil {
+			return nil, err
+		}
+	}
+
+	installed, err := installedInRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		for name := range installed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	results := make([]repo.VerifyResult, 0, len(names))
+	for _, name := range names {
+		pkg, ok := installed[name]
+		if !ok {
+			return nil, fmt.Errorf("Package %v is not installed", name)
+		}
+
+		res := pkg.set.Verify(root)
+		if restore && !res.Ok() {
+			log.Info.Format("Restoring %v", pkg.set.PkgInfo.PrettyString())
+			if err := pkg.repo.Restore(pkg.set, res, root); err != nil {
+				return nil, err
+			}
+			res = pkg.set.Verify(root)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
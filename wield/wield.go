@@ -12,6 +12,7 @@ import (
 	"github.com/serenitylinux/libspack/hash"
 	"github.com/serenitylinux/libspack/repo"
 	"github.com/serenitylinux/libspack/spakg"
+	"github.com/serenitylinux/libspack/transaction"
 )
 import . "github.com/serenitylinux/libspack/misc"
 
@@ -21,12 +22,17 @@ func Wield(file string, destdir string) error {
 		return err
 	}
 
+	err = repo.CheckCollisions([]*spakg.Spakg{spkg}, destdir, false)
+	if err != nil {
+		return err
+	}
+
 	err = PreInstall(spkg, destdir)
 	if err != nil {
 		return err
 	}
 
-	err = ExtractCheckCopy(file, destdir)
+	err = ExtractCheckCopy(file, destdir, nil)
 	if err != nil {
 		return err
 	}
@@ -46,19 +52,19 @@ func Ldconfig(destdir string) error {
 	return RunCommand(exec.Command("ldconfig", "-r", destdir), log.Debug, os.Stderr)
 }
 
-func hasPart(part string, spkg *spakg.Spakg) bool {
+func hasPart(part string, pkginstall string) bool {
 	cmd := `
 		%[1]s
 		
 		declare -f %[2]s > /dev/null
 `
-	cmd = fmt.Sprintf(cmd, spkg.Pkginstall, part)
+	cmd = fmt.Sprintf(cmd, pkginstall, part)
 	err := RunCommand(exec.Command("bash", "-c", cmd), log.Debug, os.Stderr)
 
 	return err == nil
 }
 
-func runPart(part string, spkg *spakg.Spakg, destdir string) error {
+func runPart(part string, pkginstall string, destdir string) error {
 	cmd := `
 		%[1]s
 		if ! [ -d /dev/ ]; then
@@ -67,7 +73,7 @@ func runPart(part string, spkg *spakg.Spakg, destdir string) error {
 		
 		%[2]s
 `
-	cmd = fmt.Sprintf(cmd, spkg.Pkginstall, part)
+	cmd = fmt.Sprintf(cmd, pkginstall, part)
 
 	bash := exec.Command("bash", "-c", cmd)
 	if filepath.Clean(destdir) != "/" {
@@ -83,9 +89,9 @@ func runPart(part string, spkg *spakg.Spakg, destdir string) error {
 }
 
 func PreInstall(pkg *spakg.Spakg, destdir string) error {
-	if hasPart("pre_install", pkg) {
+	if hasPart("pre_install", pkg.Pkginstall) {
 		HeaderFormat("PreInstall %s", pkg.Control.Name)
-		err := runPart("pre_install", pkg, destdir)
+		err := runPart("pre_install", pkg.Pkginstall, destdir)
 		if err != nil {
 			return err
 		}
@@ -94,9 +100,31 @@ func PreInstall(pkg *spakg.Spakg, destdir string) error {
 	return nil
 }
 func PostInstall(pkg *spakg.Spakg, destdir string) error {
-	if hasPart("post_install", pkg) {
+	if hasPart("post_install", pkg.Pkginstall) {
 		HeaderFormat("PostInstall %s", pkg.Control.Name)
-		err := runPart("post_install", pkg, destdir)
+		err := runPart("post_install", pkg.Pkginstall, destdir)
+		if err != nil {
+			return err
+		}
+		PrintSuccess()
+	}
+	return nil
+}
+func PreRemove(set *repo.PkgInstallSet, destdir string) error {
+	if hasPart("pre_remove", set.Pkginstall) {
+		HeaderFormat("PreRemove %s", set.Control.Name)
+		err := runPart("pre_remove", set.Pkginstall, destdir)
+		if err != nil {
+			return err
+		}
+		PrintSuccess()
+	}
+	return nil
+}
+func PostRemove(set *repo.PkgInstallSet, destdir string) error {
+	if hasPart("post_remove", set.Pkginstall) {
+		HeaderFormat("PostRemove %s", set.Control.Name)
+		err := runPart("post_remove", set.Pkginstall, destdir)
 		if err != nil {
 			return err
 		}
@@ -105,7 +133,9 @@ func PostInstall(pkg *spakg.Spakg, destdir string) error {
 	return nil
 }
 
-func ExtractCheckCopy(pkgfile string, destdir string) error {
+//Every file replaced or removed in destdir is journaled in tx, which may be nil
+//Collisions must already have been checked by the caller
+func ExtractCheckCopy(pkgfile string, destdir string, tx *transaction.Transaction) error {
 
 	tmpDir, _ := ioutil.TempDir(os.TempDir(), "wield")
 	defer os.RemoveAll(tmpDir)
@@ -137,17 +167,17 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 		}
 
 		if !f.IsDir() && !IsSymlink(f) {
-			origSum, exists := pkg.Md5sums[path]
+			origSum, exists := pkg.Hashes[path]
 			if !exists {
 				return errors.New(fmt.Sprintf("Sum for %s does not exist", path))
 			}
 
-			sum, erri := hash.Md5sum(path)
+			sum, erri := hash.SumAs(origSum, path)
 			if erri != nil {
-				return errors.New(fmt.Sprintf("Cannot compute sum of %s", path))
+				return errors.New(fmt.Sprintf("Cannot compute sum of %s: %s", path, erri))
 			}
 
-			if origSum != sum {
+			if !hash.Equal(origSum, sum) {
 				return errors.New(fmt.Sprintf("Sum of %s does not match. Expected %s, calculated %s", path, origSum, sum))
 			}
 			log.Debug.Format("%s\t: %s", sum, path)
@@ -166,6 +196,22 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 
 	HeaderFormat("Installing %s", pkg.Control.Name)
 
+	prev, _ := repo.GetPackageInstalledByName(pkg.Control.Name, destdir)
+
+	//Config files changed locally are kept, the new version is put next to them
+	isModifiedConfig := func(path string) bool {
+		if !pkg.Control.IsConfig(path) {
+			return false
+		}
+		if prev == nil {
+			return true //Not ours, someone put it there
+		}
+		if _, owned := prev.Hashes[path]; !owned {
+			return true
+		}
+		return prev.IsModifiedConfig(path, destdir)
+	}
+
 	copyWalk := func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -180,6 +226,10 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 				return e
 			}
 
+			if e = tx.Backup(destPath); e != nil {
+				return e
+			}
+
 			//Let's just wing it!
 			os.Remove(destPath)
 
@@ -189,21 +239,42 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 			}
 		} else if f.IsDir() {
 			if !PathExists(destPath) {
+				if e := tx.Backup(destPath); e != nil {
+					return e
+				}
 				e := os.MkdirAll(destPath, f.Mode())
 				if e != nil {
 					return e
 				}
 			}
 		} else {
-			var currhash string
+			exists := PathExists(destPath)
+			unchanged := false
 			var e error
-			if PathExists(destPath) {
-				currhash, e = hash.Md5sum(destPath)
+			if exists {
+				unchanged, e = hash.Verify(destPath, pkg.Hashes[path])
 				if e != nil {
 					return e
 				}
 			}
-			if currhash != pkg.Md5sums[path] {
+			if exists && !unchanged && isModifiedConfig(path) {
+				newPath := destPath + repo.NewConfigSuffix
+				log.Info.Format("Keeping modified %v, new version saved as %v", destPath, newPath)
+				if e = tx.Backup(newPath); e != nil {
+					return e
+				}
+				os.Remove(newPath)
+				if e = CopyFile(fsPath, newPath); e != nil {
+					return e
+				}
+				if e = repo.AddPendingConfig(path, destdir, tx); e != nil {
+					return e
+				}
+				destPath = newPath
+			} else if !unchanged {
+				if e = tx.Backup(destPath); e != nil {
+					return e
+				}
 				if PathExists(destPath) {
 					log.Debug.Format("Removing current %v", destPath)
 					e = os.Remove(destPath)
@@ -225,7 +296,6 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 		os.Lchown(destPath, uid, gid)
 		os.Chmod(destPath, f.Mode())
 		return nil
-		//TODO collisions and changed conf files
 	}
 	InDir(fsDir, func() {
 		err = filepath.Walk(".", copyWalk)
@@ -234,13 +304,26 @@ func ExtractCheckCopy(pkgfile string, destdir string) error {
 		return err
 	}
 
-	if prev, _ := repo.GetPackageInstalledByName(pkg.Control.Name, destdir); prev != nil {
+	if prev != nil {
 		log.Debug.Format("Removing files from old version %s", prev.PkgInfo.PrettyString())
 		//TODO remove empty leftover dirs
 		for oldf, _ := range prev.Hashes {
-			_, skip := pkg.Md5sums[oldf]
+			_, skip := pkg.Hashes[oldf]
+			if !skip {
+				skip, err = repo.IsOwnedByOther(oldf, pkg.Control.Name, destdir)
+				if err != nil {
+					return err
+				}
+			}
+			if !skip && prev.IsModifiedConfig(oldf, destdir) {
+				log.Info.Format("Keeping modified %s from old version", destdir+oldf)
+				skip = true
+			}
 			if !skip {
 				log.Debug.Format("Removing %s", destdir+oldf)
+				if err = tx.Backup(destdir + oldf); err != nil {
+					return err
+				}
 				err = os.RemoveAll(destdir + oldf)
 				if err != nil {
 					log.Warn.Format("Could not remove %s from old version, %v", destdir+oldf, err)